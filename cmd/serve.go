@@ -13,6 +13,8 @@ var (
 	cfgFile string
 	devel   bool
 	port    int
+	record  string
+	replay  string
 
 	serveCmd = &cobra.Command{
 		Use:     "serve",
@@ -24,6 +26,7 @@ var (
 	}
 
 	errNilEngine = fmt.Errorf("serve cmd aborted: nil engine")
+	errFixtures  = fmt.Errorf("serve cmd aborted: the fixtures can not be recorded and replayed at the same time")
 )
 
 func init() {
@@ -32,6 +35,8 @@ func init() {
 	serveCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "api2html.conf", "Path to the configuration filename")
 	serveCmd.PersistentFlags().BoolVarP(&devel, "devel", "d", false, "Enable the devel")
 	serveCmd.PersistentFlags().IntVarP(&port, "port", "p", 8080, "Listen port")
+	serveCmd.PersistentFlags().StringVar(&record, "record", "", "Record the backend responses into the fixtures folder")
+	serveCmd.PersistentFlags().StringVar(&replay, "replay", "", "Serve the backend responses from the fixtures folder")
 }
 
 type engineWrapper interface {
@@ -49,6 +54,13 @@ type serveWrapper struct {
 }
 
 func (s serveWrapper) Serve(_ *cobra.Command, _ []string) error {
+	f, err := newFixtures(record, replay)
+	if err != nil {
+		log.Println("engine creation aborted:", err.Error())
+		return err
+	}
+	engine.UseFixtures(f)
+
 	eW, err := s.eF(cfgFile, devel)
 	if err != nil {
 		log.Println("engine creation aborted:", err.Error())
@@ -63,3 +75,17 @@ func (s serveWrapper) Serve(_ *cobra.Command, _ []string) error {
 
 	return eW.Run(fmt.Sprintf(":%d", port))
 }
+
+func newFixtures(record, replay string) (*engine.Fixtures, error) {
+	switch {
+	case record != "" && replay != "":
+		return nil, errFixtures
+	case record != "":
+		log.Println("recording the backend responses into", record)
+		return engine.NewFixtures(record, engine.FixturesRecord)
+	case replay != "":
+		log.Println("replaying the backend responses from", replay)
+		return engine.NewFixtures(replay, engine.FixturesReplay)
+	}
+	return nil, nil
+}
@@ -87,3 +87,25 @@ type erroredEngine struct {
 func (e erroredEngine) Run(_ ...string) error {
 	return e.err
 }
+
+func Test_newFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api2html-fixtures")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	if f, err := newFixtures("", ""); err != nil || f != nil {
+		t.Errorf("unexpected result: %v, %v", f, err)
+	}
+	if _, err := newFixtures(dir, dir); err != errFixtures {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if f, err := newFixtures(dir, ""); err != nil || f.Mode != engine.FixturesRecord {
+		t.Errorf("unexpected result: %v, %v", f, err)
+	}
+	if f, err := newFixtures("", dir); err != nil || f.Mode != engine.FixturesReplay {
+		t.Errorf("unexpected result: %v, %v", f, err)
+	}
+}
@@ -2,7 +2,23 @@ package engine
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gregjones/httpcache"
@@ -10,26 +26,212 @@ import (
 	nrgin "github.com/newrelic/go-agent/_integrations/nrgin/v1"
 )
 
-var (
-	cachedTransport  = httpcache.NewMemoryCacheTransport()
-	cachedHTTPClient = http.Client{Transport: cachedTransport}
-)
-
 // DefaultClient returns a Dackend to the received URLPattern with the default http client
 // from the stdlib
 func DefaultClient(URLPattern string) Backend {
 	return NewBackend(http.DefaultClient, URLPattern)
 }
 
-// CachedClient returns a Dackend to the received URLPattern with a in-memory cache aware
-// http client
+// CachedClient returns a Dackend to the received URLPattern with an http client aware of the
+// default cache
 func CachedClient(URLPattern string) Backend {
-	return NewBackend(&cachedHTTPClient, URLPattern)
+	c, _ := lookupCache(DefaultCache)
+	return NewBackend(NewCachedHTTPClient(c), URLPattern)
+}
+
+// NewHTTPClient returns an http client with the timeouts and TLS settings defined by the received
+// config, storing the cacheable responses in the received cache
+func NewHTTPClient(cfg ClientConfig, c httpcache.Cache) (*http.Client, error) {
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		var err error
+		if tlsConfig, err = NewTLSConfig(*cfg.TLS); err != nil {
+			return nil, err
+		}
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   parseDuration(cfg.ConnectTimeout, 30*time.Second),
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	return &http.Client{
+		Transport: &httpcache.Transport{
+			Transport:           transport,
+			Cache:               c,
+			MarkCachedResponses: true,
+		},
+		Timeout: parseDuration(cfg.Timeout, 0),
+	}, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig creates the TLS settings defined by the received config, loading the referenced
+// certificate authorities and client certificate
+func NewTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: cfg.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.MinVersion != "" {
+		v, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls version: %s", cfg.MinVersion)
+		}
+		tlsConfig.MinVersion = v
+	}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// RetryBackend decorates the received Backend, retrying the failed requests the given number
+// of times and doubling the waiting time after every attempt. Transport errors, timeouts and
+// responses with a 502, 503 or 504 status code are retried, so it should only wrap backends
+// doing idempotent calls. The retries stop as soon as the request of the received context is done
+func RetryBackend(next Backend, retries int, backoff time.Duration) Backend {
+	return func(params map[string]string, headers map[string]string, c *gin.Context) (*http.Response, error) {
+		var done <-chan struct{}
+		if c != nil && c.Request != nil {
+			done = c.Request.Context().Done()
+		}
+		resp, err := next(params, headers, c)
+		for i := 0; i < retries && shouldRetry(resp, err); i++ {
+			select {
+			case <-time.After(backoff << uint(i)):
+			case <-done:
+				return resp, err
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+			resp, err = next(params, headers, c)
+		}
+		return resp, err
+	}
+}
+
+// shouldRetry reports if the received result is worth retrying. Errors that will happen again,
+// as the ones from the circuit breaker, the allowed hosts or the parsing of the URL, are not
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		switch e := err.(type) {
+		case *url.Error:
+			return e.Op != "parse" && e.Err != context.Canceled
+		case net.Error:
+			return true
+		}
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
 }
 
 // NewBackend creates a Backend with the received http client and url pattern
 func NewBackend(client *http.Client, URLPattern string) Backend {
-	urlPattern := []byte(URLPattern)
+	return NewBackendWithOptions(client, URLPattern, BackendOptions{})
+}
+
+// BackendOptions contains the optional behaviours of a Backend
+type BackendOptions struct {
+	// Query is the list of query string params to forward from the incoming request.
+	// A "*" forwards all of them
+	Query []string
+	// Headers is the policy to apply to the headers of the backend requests
+	Headers HeaderPolicy
+	// RawParams is the list of params to insert into the URL pattern without escaping them
+	RawParams []string
+	// AllowedHosts is the list of hosts the backend requests can be sent to. Any host is allowed
+	// if empty
+	AllowedHosts []string
+	// Auth is the source of the bearer tokens to attach to the backend requests, if any
+	Auth TokenSource
+	// GraphQL is the query to post to the backend, if it is a GraphQL one
+	GraphQL *GraphQLQuery
+}
+
+// GraphQLQuery is a GraphQL query with the params providing the values of its variables. The values
+// of the variables declared as Int, Float or Boolean by the query are sent as JSON numbers and
+// booleans, and the rest of them as strings
+type GraphQLQuery struct {
+	// Query is the GraphQL query
+	Query string
+	// Variables maps the names of the query variables to the names of the params
+	Variables map[string]string
+}
+
+var graphQLVariableDefinition = regexp.MustCompile(`\$(\w+)\s*:\s*(\w+)`)
+
+func (q *GraphQLQuery) body(params map[string]string) ([]byte, error) {
+	types := map[string]string{}
+	for _, m := range graphQLVariableDefinition.FindAllStringSubmatch(q.Query, -1) {
+		types[m[1]] = m[2]
+	}
+	variables := make(map[string]interface{}, len(q.Variables))
+	for name, param := range q.Variables {
+		v, ok := params[param]
+		if !ok {
+			continue
+		}
+		value, err := graphQLValue(types[name], v)
+		if err != nil {
+			return nil, fmt.Errorf("the variable %s is not a valid %s: %s", name, types[name], v)
+		}
+		variables[name] = value
+	}
+	return json.Marshal(map[string]interface{}{
+		"query":     q.Query,
+		"variables": variables,
+	})
+}
+
+// graphQLValue converts the param value to the scalar type of the variable
+func graphQLValue(scalar, v string) (interface{}, error) {
+	switch scalar {
+	case "Int":
+		return strconv.ParseInt(v, 10, 64)
+	case "Float":
+		return strconv.ParseFloat(v, 64)
+	case "Boolean":
+		return strconv.ParseBool(v)
+	}
+	return v, nil
+}
+
+// NewBackendWithOptions creates a Backend with the received http client, url pattern and options.
+// The responses are recorded or replayed if fixtures are in use (see UseFixtures)
+func NewBackendWithOptions(client *http.Client, URLPattern string, opts BackendOptions) Backend {
+	newRequest := newRequestFactory(URLPattern, opts)
 	actualTransport := client.Transport
 	return func(params map[string]string, headers map[string]string, c *gin.Context) (*http.Response, error) {
 		if newrelicApp != nil {
@@ -37,27 +239,261 @@ func NewBackend(client *http.Client, URLPattern string) Backend {
 			client.Transport = newrelic.NewRoundTripper(nrgin.Transaction(c), actualTransport)
 		}
 
-		req, err := http.NewRequest("GET", string(replaceParams(urlPattern, params)), nil)
+		req, err := newRequest(params, headers, c)
+		if err != nil {
+			return nil, err
+		}
+		f := fixtures
+		if opts.Auth == nil || f != nil && f.Mode == FixturesReplay {
+			return do(client, req, f)
+		}
+
+		token, err := opts.Auth.Token()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := do(client, req, f)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized {
+			opts.Auth.Invalidate(token)
+		}
+		return resp, err
+	}
+}
+
+func do(client *http.Client, req *http.Request, f *Fixtures) (*http.Response, error) {
+	if f == nil {
+		return client.Do(req)
+	}
+	return f.Do(client, req)
+}
+
+// RequestKeyFunc returns the key identifying the backend request for the received params,
+// headers and context
+type RequestKeyFunc func(params map[string]string, headers map[string]string, c *gin.Context) string
+
+// NewRequestKeyFunc returns a RequestKeyFunc for the backends created with the received url
+// pattern and options. The key of a request is composed by its final URL, headers and body
+func NewRequestKeyFunc(URLPattern string, opts BackendOptions) RequestKeyFunc {
+	newRequest := newRequestFactory(URLPattern, opts)
+	return func(params map[string]string, headers map[string]string, c *gin.Context) string {
+		req, err := newRequest(params, headers, c)
+		if err != nil {
+			return ""
+		}
+		names := make([]string, 0, len(req.Header))
+		for k := range req.Header {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		key := &bytes.Buffer{}
+		key.WriteString(req.URL.String())
+		for _, k := range names {
+			fmt.Fprintf(key, "\n%s: %s", k, strings.Join(req.Header[k], ", "))
+		}
+		if req.GetBody != nil {
+			if body, err := req.GetBody(); err == nil {
+				key.WriteString("\n\n")
+				io.Copy(key, body)
+			}
+		}
+		return key.String()
+	}
+}
+
+type requestFactory func(params map[string]string, headers map[string]string, c *gin.Context) (*http.Request, error)
+
+func newRequestFactory(URLPattern string, opts BackendOptions) requestFactory {
+	urlPattern := []byte(URLPattern)
+	staticHeaders := make(map[string]string, len(opts.Headers.Static))
+	for k, v := range opts.Headers.Static {
+		staticHeaders[k] = os.ExpandEnv(v)
+	}
+	raw := make(map[string]bool, len(opts.RawParams))
+	for _, k := range opts.RawParams {
+		raw[k] = true
+	}
+	return func(params map[string]string, headers map[string]string, c *gin.Context) (*http.Request, error) {
+		u, err := url.Parse(string(replaceParamsWithRaw(urlPattern, params, raw)))
+		if err != nil {
+			return nil, err
+		}
+		if len(opts.AllowedHosts) > 0 && !isAllowedHost(u, opts.AllowedHosts) {
+			log.Println("backend host not allowed:", u.Host)
+			return nil, ErrHostNotAllowed
+		}
+		if c != nil && c.Request != nil {
+			u.RawQuery = forwardQuery(u.RawQuery, c.Request.URL.Query(), opts.Query)
+		}
+
+		req, err := newBackendRequest(u, params, opts.GraphQL)
 		if err != nil {
 			return nil, err
 		}
 		for k, v := range headers {
 			req.Header.Add(k, v)
 		}
-		return client.Do(req)
+		if c != nil && c.Request != nil {
+			opts.Headers.forward(c.Request.Header, req.Header)
+		}
+		for k, v := range staticHeaders {
+			req.Header.Set(k, v)
+		}
+		return req, nil
 	}
 }
 
+func newBackendRequest(u *url.URL, params map[string]string, q *GraphQLQuery) (*http.Request, error) {
+	if q == nil {
+		return http.NewRequest("GET", u.String(), nil)
+	}
+	body, err := q.body(params)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+func (h HeaderPolicy) forward(src, dst http.Header) {
+	for _, k := range h.Forward {
+		vs, ok := src[http.CanonicalHeaderKey(k)]
+		if !ok {
+			continue
+		}
+		name := k
+		if n, ok := h.Rename[k]; ok {
+			name = n
+		}
+		dst[http.CanonicalHeaderKey(name)] = append([]string{}, vs...)
+	}
+}
+
+func parseDuration(s string, d time.Duration) time.Duration {
+	if v, err := time.ParseDuration(s); err == nil {
+		return v
+	}
+	return d
+}
+
+func forwardQuery(rawQuery string, query url.Values, whitelist []string) string {
+	forwarded := url.Values{}
+	for _, k := range whitelist {
+		if k == "*" {
+			forwarded = query
+			break
+		}
+		if vs, ok := query[k]; ok {
+			forwarded[k] = vs
+		}
+	}
+	if len(forwarded) == 0 {
+		return rawQuery
+	}
+	if rawQuery == "" {
+		return forwarded.Encode()
+	}
+	return rawQuery + "&" + forwarded.Encode()
+}
+
 func replaceParams(URLPattern []byte, params map[string]string) []byte {
+	return replaceParamsWithRaw(URLPattern, params, nil)
+}
+
+// replaceParamsWithRaw replaces the placeholders of the URL pattern with the values of the params
+// in a single pass, so the substituted values are never parsed as placeholders. A placeholder is
+// only replaced if its name is not followed by more name characters (so the param 'id' does not
+// touch ':idx') and the values are escaped as path segments or as query string components,
+// depending on their position, unless their param is listed as raw
+func replaceParamsWithRaw(URLPattern []byte, params map[string]string, raw map[string]bool) []byte {
 	if len(params) == 0 {
 		return URLPattern
 	}
-	buff := URLPattern
-	for k, v := range params {
-		key := []byte{}
-		key = append(key, ":"...)
-		key = append(key, k...)
-		buff = bytes.Replace(buff, key, []byte(v), -1)
+	queryStart := bytes.IndexByte(URLPattern, '?')
+	buff := make([]byte, 0, len(URLPattern))
+	for i := 0; i < len(URLPattern); i++ {
+		if URLPattern[i] != ':' {
+			buff = append(buff, URLPattern[i])
+			continue
+		}
+		name := matchParam(URLPattern[i+1:], params)
+		if name == "" {
+			buff = append(buff, URLPattern[i])
+			continue
+		}
+		value := params[name]
+		switch {
+		case raw[name]:
+		case queryStart >= 0 && i > queryStart:
+			value = url.QueryEscape(value)
+		default:
+			value = escapePathSegment(value)
+		}
+		buff = append(buff, value...)
+		i += len(name)
 	}
 	return buff
 }
+
+// usedParams returns the names of the params replaced in the pattern by replaceParamsWithRaw
+func usedParams(URLPattern []byte, params map[string]string) []string {
+	names := []string{}
+	for i := 0; i < len(URLPattern); i++ {
+		if URLPattern[i] != ':' {
+			continue
+		}
+		if name := matchParam(URLPattern[i+1:], params); name != "" {
+			names = append(names, name)
+			i += len(name)
+		}
+	}
+	return names
+}
+
+// matchParam returns the longest param name matching a whole placeholder token at the beginning
+// of the received pattern
+func matchParam(pattern []byte, params map[string]string) string {
+	match := ""
+	for k := range params {
+		if len(k) <= len(match) || !bytes.HasPrefix(pattern, []byte(k)) {
+			continue
+		}
+		if len(pattern) > len(k) && isParamNameChar(pattern[len(k)]) {
+			continue
+		}
+		match = k
+	}
+	return match
+}
+
+func isParamNameChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// escapePathSegment escapes the value as a path segment, including the dot segments
+func escapePathSegment(value string) string {
+	if value == "." || value == ".." {
+		return strings.Replace(value, ".", "%2E", -1)
+	}
+	return url.PathEscape(value)
+}
+
+// isAllowedHost checks if the host is in the received list. The entries of the list can be host
+// names, matching any port, host names with port or wildcards like *.example.com
+func isAllowedHost(u *url.URL, allowed []string) bool {
+	hostname := u.Hostname()
+	for _, h := range allowed {
+		switch {
+		case h == u.Host, h == hostname:
+			return true
+		case strings.HasPrefix(h, "*.") && strings.HasSuffix(hostname, h[1:]):
+			return true
+		}
+	}
+	return false
+}
@@ -2,12 +2,28 @@ package engine
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gregjones/httpcache"
 )
 
 var (
@@ -59,3 +75,437 @@ func TestReplaceParams(t *testing.T) {
 		t.Error("The replace is not working as expected.")
 	}
 }
+
+func TestNewBackendWithOptions_forwardQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for i, tc := range []struct {
+		pattern  string
+		query    []string
+		expected string
+	}{
+		{"/test/:param", nil, "/test/replacetest"},
+		{"/test/:param", []string{"a"}, "/test/replacetest?a=1"},
+		{"/test/:param?x=:param", []string{"b", "c"}, "/test/replacetest?x=replacetest&b=2&b=3"},
+		{"/test/:param", []string{"*"}, "/test/replacetest?a=1&b=2&b=3"},
+	} {
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.RequestURI() != tc.expected {
+				t.Errorf("#%d: unexpected URL: %s", i, r.URL.RequestURI())
+			}
+		}))
+		backend := NewBackendWithOptions(http.DefaultClient, mockServer.URL+tc.pattern, BackendOptions{Query: tc.query})
+		context, _ := gin.CreateTestContext(httptest.NewRecorder())
+		context.Request, _ = http.NewRequest("GET", "/?a=1&b=2&b=3", nil)
+		resp, err := backend(params, headers, context)
+		if err != nil {
+			t.Errorf("#%d: backend response error: %s", i, err.Error())
+		} else if resp.StatusCode != 200 {
+			t.Errorf("#%d: invalid status code: %d", i, resp.StatusCode)
+		}
+		mockServer.Close()
+	}
+}
+
+func TestReplaceParams_escaping(t *testing.T) {
+	subject := []byte("/test/:param?q=:param&r=:other")
+	result := replaceParams(subject, map[string]string{
+		"param": "a b/c?d",
+		"other": "x&y=z",
+	})
+	expected := "/test/a%20b%2Fc%3Fd?q=a+b%2Fc%3Fd&r=x%26y%3Dz"
+	if string(result) != expected {
+		t.Errorf("unexpected result. have: %s, want: %s", string(result), expected)
+	}
+}
+
+func TestNewBackendWithOptions_headers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	os.Setenv("API2HTML_TEST_KEY", "secret")
+	defer os.Unsetenv("API2HTML_TEST_KEY")
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range map[string]string{
+			"X-Test":          "testing",
+			"Authorization":   "Bearer token",
+			"Accept-Language": "es-ES",
+			"X-Tenant-Id":     "tenant",
+			"X-Api-Key":       "secret",
+			"X-Static":        "static value",
+		} {
+			if r.Header.Get(k) != v {
+				t.Errorf("unexpected value for the header %s: %s", k, r.Header.Get(k))
+			}
+		}
+		if r.Header.Get("X-Tenant") != "" || r.Header.Get("Cookie") != "" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+	}))
+	defer mockServer.Close()
+
+	backend := NewBackendWithOptions(http.DefaultClient, mockServer.URL+string(urlPattern), BackendOptions{
+		Headers: HeaderPolicy{
+			Forward: []string{"Authorization", "accept-language", "X-Tenant", "X-Missing"},
+			Rename:  map[string]string{"X-Tenant": "X-Tenant-Id"},
+			Static: map[string]string{
+				"X-Api-Key": "${API2HTML_TEST_KEY}",
+				"X-Static":  "static value",
+			},
+		},
+	})
+	context, _ := gin.CreateTestContext(httptest.NewRecorder())
+	context.Request, _ = http.NewRequest("GET", "/", nil)
+	context.Request.Header.Set("Authorization", "Bearer token")
+	context.Request.Header.Set("Accept-Language", "es-ES")
+	context.Request.Header.Set("X-Tenant", "tenant")
+	context.Request.Header.Set("Cookie", "a=b")
+	resp, err := backend(params, headers, context)
+	if err != nil {
+		t.Errorf("Backend response error: %s", err.Error())
+		return
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Invalid status code.")
+	}
+}
+
+func TestRetryBackend(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "Hi")
+	}))
+	defer mockServer.Close()
+
+	backend := RetryBackend(DefaultClient(mockServer.URL+string(urlPattern)), 2, time.Millisecond)
+	resp, err := backend(params, headers, nil)
+	if err != nil {
+		t.Errorf("Backend response error: %s", err.Error())
+		return
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("Invalid status code: %d", resp.StatusCode)
+	}
+	if calls != 3 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestRetryBackend_errors(t *testing.T) {
+	for i, tc := range []struct {
+		err   error
+		calls int
+	}{
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: fmt.Errorf("connection refused")}, 3},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: context.Canceled}, 1},
+		{&url.Error{Op: "parse", URL: "http://%zz", Err: fmt.Errorf("invalid URL escape")}, 1},
+		{ErrHostNotAllowed, 1},
+		{ErrCircuitOpen, 1},
+	} {
+		calls := 0
+		backend := RetryBackend(func(_, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+			calls++
+			return nil, tc.err
+		}, 2, time.Millisecond)
+		if _, err := backend(params, headers, nil); err != tc.err {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+		if calls != tc.calls {
+			t.Errorf("#%d: unexpected number of calls: %d", i, calls)
+		}
+	}
+}
+
+func TestRetryBackend_canceled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+	c.Request = c.Request.WithContext(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	backend := RetryBackend(DefaultClient(mockServer.URL+string(urlPattern)), 2, time.Minute)
+	resp, err := backend(params, headers, c)
+	if err != nil {
+		t.Errorf("Backend response error: %s", err.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Invalid status code: %d", resp.StatusCode)
+	}
+	if calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("the backoff was not interrupted: %d calls in %s", calls, time.Since(start))
+	}
+}
+
+func TestNewHTTPClient_timeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprintln(w, "Hi")
+	}))
+	defer mockServer.Close()
+
+	client, err := NewHTTPClient(ClientConfig{Timeout: "10ms"}, httpcache.NewMemoryCache())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	backend := NewBackend(client, mockServer.URL+string(urlPattern))
+	if _, err := backend(params, headers, nil); err == nil {
+		t.Error("timeout error expected")
+	}
+}
+
+func TestNewRequestKeyFunc(t *testing.T) {
+	subject := NewRequestKeyFunc("http://example.com/:param", BackendOptions{
+		Query:   []string{"a"},
+		Headers: HeaderPolicy{Forward: []string{"Authorization"}},
+	})
+	context, _ := gin.CreateTestContext(httptest.NewRecorder())
+	context.Request, _ = http.NewRequest("GET", "/?a=1&b=2", nil)
+	context.Request.Header.Set("Authorization", "Bearer token")
+	context.Request.Header.Set("Cookie", "a=b")
+
+	expected := "http://example.com/replacetest?a=1\nAuthorization: Bearer token\nX-Test: testing"
+	if key := subject(params, headers, context); key != expected {
+		t.Errorf("unexpected key: %s", key)
+	}
+}
+
+func TestReplaceParamsWithRaw(t *testing.T) {
+	for i, tc := range []struct {
+		pattern  string
+		params   map[string]string
+		raw      map[string]bool
+		expected string
+	}{
+		{"/a/:id/:idx", map[string]string{"id": "1", "idx": "2"}, nil, "/a/1/2"},
+		{"/a/:idx/:id", map[string]string{"id": "1"}, nil, "/a/:idx/1"},
+		{"/a/:id-:slug", map[string]string{"id": "1", "slug": "b"}, nil, "/a/1-b"},
+		{"/a/:first/:second", map[string]string{"first": ":second", "second": "2"}, nil, "/a/:second/2"},
+		{"/a/:id", map[string]string{"id": ".."}, nil, "/a/%2E%2E"},
+		{"/a/:id", map[string]string{"id": "../b#c"}, nil, "/a/..%2Fb%23c"},
+		{"/a/:path", map[string]string{"path": "b/c"}, map[string]bool{"path": true}, "/a/b/c"},
+		{"http://example.com:8080/:id", map[string]string{"id": "1"}, nil, "http://example.com:8080/1"},
+	} {
+		if result := string(replaceParamsWithRaw([]byte(tc.pattern), tc.params, tc.raw)); result != tc.expected {
+			t.Errorf("#%d: unexpected result. have: %s, want: %s", i, result, tc.expected)
+		}
+	}
+}
+
+func TestNewBackendWithOptions_allowedHosts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hi")
+	}))
+	defer mockServer.Close()
+	mockURL, _ := url.Parse(mockServer.URL)
+
+	for i, tc := range []struct {
+		pattern string
+		allowed []string
+		err     error
+	}{
+		{mockServer.URL + "/:param", nil, nil},
+		{mockServer.URL + "/:param", []string{mockURL.Host}, nil},
+		{mockServer.URL + "/:param", []string{mockURL.Hostname()}, nil},
+		{mockServer.URL + "/:param", []string{"api.example.com"}, ErrHostNotAllowed},
+		{"http://:param/test", []string{mockURL.Hostname()}, ErrHostNotAllowed},
+		{"http://evil.:param/test", []string{"*.example.com"}, ErrHostNotAllowed},
+	} {
+		backend := NewBackendWithOptions(http.DefaultClient, tc.pattern, BackendOptions{AllowedHosts: tc.allowed})
+		_, err := backend(params, headers, nil)
+		if err != tc.err {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestIsAllowedHost(t *testing.T) {
+	for i, tc := range []struct {
+		url      string
+		expected bool
+	}{
+		{"http://api.example.com/a", true},
+		{"http://api.example.com:8080/a", true},
+		{"http://other.example.com:8080/a", false},
+		{"http://other.example.com:9090/a", true},
+		{"http://a.b.internal.net/a", true},
+		{"http://internal.net/a", false},
+		{"http://evilinternal.net/a", false},
+	} {
+		u, _ := url.Parse(tc.url)
+		if isAllowedHost(u, []string{"api.example.com", "other.example.com:9090", "*.internal.net"}) != tc.expected {
+			t.Errorf("#%d: unexpected result for %s", i, tc.url)
+		}
+	}
+}
+
+func TestNewHTTPClient_tls(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir, err := ioutil.TempDir("", "api2html-tls")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	clientCert, err := writeTestCertificate(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	mockServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hi")
+	}))
+	mockServer.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	mockServer.StartTLS()
+	defer mockServer.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	serverCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: mockServer.TLS.Certificates[0].Certificate[0]})
+	if err := ioutil.WriteFile(caFile, serverCert, 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	for i, tc := range []struct {
+		cfg TLSConfig
+		ok  bool
+	}{
+		{TLSConfig{}, false},
+		{TLSConfig{CAFile: caFile}, false},
+		{TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, true},
+		{TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "example.com"}, true},
+		{TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "unknown.com"}, false},
+		{TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2"}, true},
+	} {
+		client, err := NewHTTPClient(ClientConfig{TLS: &tc.cfg}, httpcache.NewMemoryCache())
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		resp, err := NewBackend(client, mockServer.URL)(params, headers, nil)
+		if tc.ok != (err == nil) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+func TestNewTLSConfig_ko(t *testing.T) {
+	for i, cfg := range []TLSConfig{
+		{MinVersion: "2.0"},
+		{CAFile: "unknown.pem"},
+		{CAFile: "backend_test.go"},
+		{CertFile: "unknown.pem", KeyFile: "unknown.pem"},
+	} {
+		if _, err := NewTLSConfig(cfg); err == nil {
+			t.Errorf("#%d: error expected", i)
+		}
+	}
+}
+
+func writeTestCertificate(dir string) (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "api2html"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0644); err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func TestNewRequestKeyFunc_graphQL(t *testing.T) {
+	subject := NewRequestKeyFunc("http://example.com/graphql", BackendOptions{
+		GraphQL: &GraphQLQuery{Query: "{ user(id: $id) { name } }", Variables: map[string]string{"id": "param"}},
+	})
+	expected := "http://example.com/graphql\nAccept: application/json\nContent-Type: application/json\nX-Test: testing" +
+		"\n\n" + `{"query":"{ user(id: $id) { name } }","variables":{"id":"replacetest"}}`
+	if key := subject(params, headers, nil); key != expected {
+		t.Errorf("unexpected key: %s", key)
+	}
+}
+
+func TestGraphQLQuery_body(t *testing.T) {
+	q := &GraphQLQuery{
+		Query: "query Products($first: Int = 10, $min: Float, $stock: Boolean!, $id: ID!, $tags: [Int]) " +
+			"{ products(first: $first, min: $min, stock: $stock, id: $id, tags: $tags) { name } }",
+		Variables: map[string]string{"first": "a", "min": "b", "stock": "c", "id": "d", "tags": "e", "lang": "f"},
+	}
+	body, err := q.body(map[string]string{"a": "20", "b": "9.5", "c": "true", "d": "42", "e": "1", "f": "es"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var result struct {
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Error(err)
+		return
+	}
+	for name, expected := range map[string]interface{}{
+		"first": 20.0,
+		"min":   9.5,
+		"stock": true,
+		"id":    "42",
+		"tags":  "1",
+		"lang":  "es",
+	} {
+		if v := result.Variables[name]; v != expected {
+			t.Errorf("%s: unexpected value: %#v", name, v)
+		}
+	}
+
+	if _, err := q.body(map[string]string{"a": "twenty"}); err == nil || err.Error() != "the variable first is not a valid Int: twenty" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The host selection strategies of a Balancer
+const (
+	BalancerRoundRobin    = "round_robin"
+	BalancerLeastInflight = "least_inflight"
+)
+
+var balancers = &sync.Map{}
+
+// Balancers returns the state of all the registered balancers, indexed by name
+func Balancers() map[string]BalancerState {
+	result := map[string]BalancerState{}
+	balancers.Range(func(k, v interface{}) bool {
+		result[k.(string)] = v.(*Balancer).State()
+		return true
+	})
+	return result
+}
+
+// NewBalancer creates a Balancer sending the requests to the hosts of the received config through
+// the given transport, and registers it with the received name, so its state can be monitored.
+// If the config enables the health checks, the hosts are probed in background until the balancer
+// is closed
+func NewBalancer(name string, cfg BalancerConfig, next http.RoundTripper) (*Balancer, error) {
+	if len(cfg.Hosts) == 0 {
+		return nil, fmt.Errorf("balancer without hosts")
+	}
+	switch cfg.Strategy {
+	case "":
+		cfg.Strategy = BalancerRoundRobin
+	case BalancerRoundRobin, BalancerLeastInflight:
+	default:
+		return nil, fmt.Errorf("unknown balancer strategy: %s", cfg.Strategy)
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	maxFailures := cfg.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 3
+	}
+
+	b := &Balancer{
+		strategy:     cfg.Strategy,
+		maxFailures:  maxFailures,
+		ejectionTime: parseDuration(cfg.EjectionTime, 30*time.Second),
+		next:         next,
+		done:         make(chan struct{}),
+		mutex:        &sync.Mutex{},
+	}
+	for _, h := range cfg.Hosts {
+		u, err := url.Parse(h)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid balancer host: %s", h)
+		}
+		b.hosts = append(b.hosts, &balancedHost{url: u, healthy: true})
+	}
+	if cfg.HealthCheck != nil {
+		go b.probe(*cfg.HealthCheck)
+	}
+	balancers.Store(name, b)
+	return b, nil
+}
+
+// Balancer is an http.RoundTripper spreading the requests across several hosts. The hosts failing
+// consecutively are ejected for a while and, if the health checks are enabled, the unhealthy ones
+// do not receive requests. If no host is available, all of them are used
+type Balancer struct {
+	strategy     string
+	maxFailures  int
+	ejectionTime time.Duration
+	next         http.RoundTripper
+	hosts        []*balancedHost
+	counter      int
+	done         chan struct{}
+	closeOnce    sync.Once
+	mutex        *sync.Mutex
+}
+
+type balancedHost struct {
+	url          *url.URL
+	healthy      bool
+	inflight     int
+	failures     int
+	ejectedUntil time.Time
+}
+
+// BalancerState is a snapshot of the state of a Balancer
+type BalancerState struct {
+	Strategy string      `json:"strategy"`
+	Hosts    []HostState `json:"hosts"`
+}
+
+// HostState is a snapshot of the state of a host of a Balancer
+type HostState struct {
+	URL      string `json:"url"`
+	Healthy  bool   `json:"healthy"`
+	Ejected  bool   `json:"ejected"`
+	Inflight int    `json:"inflight"`
+	Failures int    `json:"consecutive_failures"`
+}
+
+// State returns a snapshot of the state of the balancer
+func (b *Balancer) State() BalancerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	state := BalancerState{Strategy: b.strategy, Hosts: make([]HostState, len(b.hosts))}
+	for i, h := range b.hosts {
+		state.Hosts[i] = HostState{h.url.String(), h.healthy, now.Before(h.ejectedUntil), h.inflight, h.failures}
+	}
+	return state
+}
+
+// Close stops the health checks of the balancer
+func (b *Balancer) Close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
+
+// RoundTrip implements the http.RoundTripper interface, replacing the scheme and host of the
+// request URL with the ones of the selected host. Errors and responses with a 5xx status code
+// are considered failures
+func (b *Balancer) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := b.pick()
+
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	u.Scheme = h.url.Scheme
+	u.Host = h.url.Host
+	if base := strings.TrimSuffix(h.url.Path, "/"); base != "" {
+		u.Path = base + u.Path
+		if u.RawPath != "" {
+			u.RawPath = strings.TrimSuffix(h.url.EscapedPath(), "/") + u.RawPath
+		}
+	}
+	r.URL = &u
+	r.Host = ""
+
+	resp, err := b.next.RoundTrip(r)
+	b.register(h, err == nil && resp.StatusCode < http.StatusInternalServerError)
+	if err != nil {
+		b.release(h)
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { b.release(h) }}
+	return resp, nil
+}
+
+func (b *Balancer) pick() *balancedHost {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	available := make([]*balancedHost, 0, len(b.hosts))
+	for _, h := range b.hosts {
+		if h.healthy && !now.Before(h.ejectedUntil) {
+			available = append(available, h)
+		}
+	}
+	if len(available) == 0 {
+		available = b.hosts
+	}
+
+	start := b.counter % len(available)
+	b.counter++
+	selected := available[start]
+	if b.strategy == BalancerLeastInflight {
+		for i := 1; i < len(available); i++ {
+			if h := available[(start+i)%len(available)]; h.inflight < selected.inflight {
+				selected = h
+			}
+		}
+	}
+	selected.inflight++
+	return selected
+}
+
+func (b *Balancer) register(h *balancedHost, ok bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if ok {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.failures >= b.maxFailures {
+		log.Println("ejecting the balanced host", h.url.String())
+		h.ejectedUntil = time.Now().Add(b.ejectionTime)
+		h.failures = 0
+	}
+}
+
+func (b *Balancer) release(h *balancedHost) {
+	b.mutex.Lock()
+	h.inflight--
+	b.mutex.Unlock()
+}
+
+func (b *Balancer) probe(cfg HealthCheckConfig) {
+	client := &http.Client{Transport: b.next, Timeout: parseDuration(cfg.Timeout, 2*time.Second)}
+	ticker := time.NewTicker(parseDuration(cfg.Interval, 10*time.Second))
+	defer ticker.Stop()
+	for {
+		for _, h := range b.hosts {
+			healthy := b.check(client, h, cfg.Path)
+			b.mutex.Lock()
+			if h.healthy != healthy {
+				log.Println("balanced host", h.url.String(), "healthy:", healthy)
+			}
+			h.healthy = healthy
+			b.mutex.Unlock()
+		}
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Balancer) check(client *http.Client, h *balancedHost, path string) bool {
+	resp, err := client.Get(strings.TrimSuffix(h.url.String(), "/") + path)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusBadRequest
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (r *releasingBody) Close() error {
+	r.once.Do(r.release)
+	return r.ReadCloser.Close()
+}
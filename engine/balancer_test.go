@@ -0,0 +1,258 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestReplica(name string, calls *int64, status *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(calls, 1)
+		if code := atomic.LoadInt64(status); code != 0 {
+			w.WriteHeader(int(code))
+			return
+		}
+		fmt.Fprintf(w, `{"replica":"%s","path":"%s"}`, name, r.URL.EscapedPath())
+	}))
+}
+
+func TestBalancer_roundRobin(t *testing.T) {
+	var callsA, callsB, statusA, statusB int64
+	replicaA := newTestReplica("a", &callsA, &statusA)
+	defer replicaA.Close()
+	replicaB := newTestReplica("b", &callsB, &statusB)
+	defer replicaB.Close()
+
+	b, err := NewBalancer("test-round-robin", BalancerConfig{
+		Hosts:        []string{replicaA.URL, replicaB.URL + "/api/"},
+		MaxFailures:  2,
+		EjectionTime: "50ms",
+	}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer b.Close()
+	backend := NewBackend(&http.Client{Transport: b}, "http://catalog/items/:param")
+
+	expected := []string{
+		`{"replica":"a","path":"/items/replacetest"}`,
+		`{"replica":"b","path":"/api/items/replacetest"}`,
+		`{"replica":"a","path":"/items/replacetest"}`,
+		`{"replica":"b","path":"/api/items/replacetest"}`,
+	}
+	for i, body := range expected {
+		resp, err := backend(params, headers, nil)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(data) != body {
+			t.Errorf("#%d: unexpected body: %s", i, string(data))
+		}
+	}
+
+	atomic.StoreInt64(&statusA, http.StatusInternalServerError)
+	for i := 0; i < 8; i++ {
+		resp, err := backend(params, headers, nil)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+	if callsA != 4 || callsB != 8 {
+		t.Errorf("unexpected number of calls: %d, %d", callsA, callsB)
+	}
+	state := Balancers()["test-round-robin"]
+	if state.Strategy != BalancerRoundRobin || len(state.Hosts) != 2 {
+		t.Errorf("unexpected state: %v", state)
+		return
+	}
+	if !state.Hosts[0].Ejected || state.Hosts[1].Ejected {
+		t.Errorf("unexpected state: %v", state)
+	}
+	for _, h := range state.Hosts {
+		if h.Inflight != 0 {
+			t.Errorf("unexpected inflight requests: %v", h)
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt64(&statusA, 0)
+	atomic.StoreInt64(&statusB, http.StatusInternalServerError)
+	for i := 0; i < 6; i++ {
+		resp, err := backend(params, headers, nil)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+	if callsA != 8 || callsB != 10 {
+		t.Errorf("unexpected number of calls: %d, %d", callsA, callsB)
+	}
+
+	atomic.StoreInt64(&statusA, http.StatusInternalServerError)
+	for i := 0; i < 4; i++ {
+		resp, err := backend(params, headers, nil)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+	if callsA+callsB != 22 {
+		t.Errorf("the requests were not sent when all the hosts were ejected: %d, %d", callsA, callsB)
+	}
+}
+
+func TestBalancer_leastInflight(t *testing.T) {
+	release := make(chan struct{})
+	var calls int64
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		fmt.Fprint(w, "slow")
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "fast")
+	}))
+	defer fast.Close()
+
+	b, err := NewBalancer("test-least-inflight", BalancerConfig{
+		Hosts:    []string{slow.URL, fast.URL},
+		Strategy: BalancerLeastInflight,
+	}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	client := &http.Client{Transport: b}
+
+	done := make(chan struct{})
+	go func() {
+		resp, err := client.Get("http://catalog/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		close(done)
+	}()
+	for atomic.LoadInt64(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://catalog/")
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(data) != "fast" {
+			t.Errorf("#%d: unexpected body: %s", i, string(data))
+		}
+	}
+	close(release)
+	<-done
+}
+
+func TestBalancer_healthCheck(t *testing.T) {
+	var callsA, callsB, statusA, statusB int64
+	replicaA := newTestReplica("a", &callsA, &statusA)
+	defer replicaA.Close()
+	replicaB := newTestReplica("b", &callsB, &statusB)
+	defer replicaB.Close()
+
+	atomic.StoreInt64(&statusA, http.StatusServiceUnavailable)
+	b, err := NewBalancer("test-health-check", BalancerConfig{
+		Hosts:       []string{replicaA.URL, replicaB.URL},
+		HealthCheck: &HealthCheckConfig{Path: "/health", Interval: "10ms"},
+	}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer b.Close()
+
+	time.Sleep(20 * time.Millisecond)
+	if state := b.State(); state.Hosts[0].Healthy || !state.Hosts[1].Healthy {
+		t.Errorf("unexpected state: %v", state)
+	}
+	client := &http.Client{Transport: b}
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get("http://catalog/")
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(data) != `{"replica":"b","path":"/"}` {
+			t.Errorf("#%d: unexpected body: %s", i, string(data))
+		}
+	}
+
+	atomic.StoreInt64(&statusA, 0)
+	time.Sleep(30 * time.Millisecond)
+	if state := b.State(); !state.Hosts[0].Healthy {
+		t.Errorf("unexpected state: %v", state)
+	}
+}
+
+func TestNewBalancer_ko(t *testing.T) {
+	for i, cfg := range []BalancerConfig{
+		{},
+		{Hosts: []string{"http://example.com"}, Strategy: "random"},
+		{Hosts: []string{"example.com"}},
+		{Hosts: []string{"http://example.com", "%"}},
+	} {
+		if _, err := NewBalancer("test-ko", cfg, nil); err == nil {
+			t.Errorf("#%d: error expected", i)
+		}
+	}
+}
+
+func TestNewHandlerConfig_balancer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var callsA, callsB, statusA, statusB int64
+	replicaA := newTestReplica("a", &callsA, &statusA)
+	defer replicaA.Close()
+	replicaB := newTestReplica("b", &callsB, &statusB)
+	defer replicaB.Close()
+
+	cfg := NewHandlerConfig(Page{
+		Name:              "balanced",
+		BackendURLPattern: "http://catalog/items/:id",
+		AllowedHosts:      []string{"api.example.com"},
+		Balancer:          &BalancerConfig{Hosts: []string{replicaA.URL, replicaB.URL}},
+	})
+	for _, expected := range []string{"a", "b", "a"} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", "/items/42", nil)
+		c.Params = gin.Params{{Key: "id", Value: "42"}}
+		result, err := cfg.ResponseGenerator(c)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if result.Data["replica"] != expected || result.Data["path"] != "/items/42" {
+			t.Errorf("unexpected result: %v", result.Data)
+		}
+	}
+	if _, ok := Balancers()["balanced"]; !ok {
+		t.Error("balancer not registered")
+	}
+}
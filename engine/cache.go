@@ -0,0 +1,228 @@
+package engine
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gregjones/httpcache"
+)
+
+// DefaultCache is the name of the cache used by the backends not selecting any other
+const DefaultCache = "default"
+
+var caches = &sync.Map{}
+
+func init() {
+	RegisterCache(DefaultCache, httpcache.NewMemoryCache())
+}
+
+// RegisterCache wraps the received cache with hit and miss counters and registers it with the
+// given name, so it can be selected by the pages and monitored
+func RegisterCache(name string, c httpcache.Cache) *CountingCache {
+	cc := &CountingCache{Cache: c}
+	caches.Store(name, cc)
+	return cc
+}
+
+// Caches returns the stats of all the registered caches, indexed by name
+func Caches() map[string]CacheStats {
+	result := map[string]CacheStats{}
+	caches.Range(func(k, v interface{}) bool {
+		result[k.(string)] = v.(*CountingCache).Stats()
+		return true
+	})
+	return result
+}
+
+func lookupCache(name string) (*CountingCache, bool) {
+	c, ok := caches.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return c.(*CountingCache), true
+}
+
+// NewCache creates a cache with the received configuration
+func NewCache(cfg CacheConfig) (httpcache.Cache, error) {
+	switch cfg.Type {
+	case "", "memory":
+		if cfg.MaxBytes > 0 {
+			return NewLRUCache(cfg.MaxBytes), nil
+		}
+		return httpcache.NewMemoryCache(), nil
+	case "disk":
+		return NewDiskCache(cfg.Path)
+	}
+	return nil, fmt.Errorf("unknown cache type: %s", cfg.Type)
+}
+
+// NewCachedHTTPClient returns an http client storing the cacheable responses in the received cache
+func NewCachedHTTPClient(c httpcache.Cache) *http.Client {
+	return &http.Client{Transport: &httpcache.Transport{Cache: c, MarkCachedResponses: true}}
+}
+
+// CacheStats contains the counters of a cache
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// CountingCache is a cache decorator counting the hits and misses of the wrapped cache
+type CountingCache struct {
+	httpcache.Cache
+	hits   uint64
+	misses uint64
+}
+
+// Get implements the httpcache.Cache interface
+func (c *CountingCache) Get(key string) ([]byte, bool) {
+	resp, ok := c.Cache.Get(key)
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+	return resp, ok
+}
+
+// Stats returns a snapshot of the counters
+func (c *CountingCache) Stats() CacheStats {
+	return CacheStats{atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)}
+}
+
+// NewLRUCache creates an in-memory cache evicting the least recently used entries when the size
+// of the stored responses exceeds the received number of bytes
+func NewLRUCache(maxBytes int64) *LRUCache {
+	return &LRUCache{
+		maxBytes: maxBytes,
+		items:    map[string]*list.Element{},
+		order:    list.New(),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// LRUCache is an in-memory cache with a size limit
+type LRUCache struct {
+	maxBytes int64
+	size     int64
+	items    map[string]*list.Element
+	order    *list.List
+	mutex    *sync.Mutex
+}
+
+type lruEntry struct {
+	key   string
+	value []byte
+}
+
+// Get implements the httpcache.Cache interface
+func (c *LRUCache) Get(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// Set implements the httpcache.Cache interface
+func (c *LRUCache) Set(key string, value []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	if int64(len(key)+len(value)) > c.maxBytes {
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key, value})
+	c.size += int64(len(key) + len(value))
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete implements the httpcache.Cache interface
+func (c *LRUCache) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+}
+
+// Size returns the number of bytes stored in the cache
+func (c *LRUCache) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+func (c *LRUCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*lruEntry)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.key) + len(entry.value))
+}
+
+// NewDiskCache creates a cache storing the responses as files in the received folder, so
+// they survive restarts
+func NewDiskCache(path string) (*DiskCache, error) {
+	if path == "" {
+		return nil, fmt.Errorf("disk cache without path")
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{path}, nil
+}
+
+// DiskCache is a cache backed by the filesystem
+type DiskCache struct {
+	path string
+}
+
+// Get implements the httpcache.Cache interface
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(c.filename(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Set implements the httpcache.Cache interface
+func (c *DiskCache) Set(key string, value []byte) {
+	f, err := ioutil.TempFile(c.path, "tmp")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err := os.Rename(f.Name(), c.filename(key)); err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Delete implements the httpcache.Cache interface
+func (c *DiskCache) Delete(key string) {
+	os.Remove(c.filename(key))
+}
+
+func (c *DiskCache) filename(key string) string {
+	h := sha1.Sum([]byte(key))
+	return filepath.Join(c.path, hex.EncodeToString(h[:]))
+}
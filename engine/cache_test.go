@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(20)
+	c.Set("a", []byte("123456789"))
+	c.Set("b", []byte("123456789"))
+	if _, ok := c.Get("a"); !ok {
+		t.Error("the entry a should be in the cache")
+	}
+	c.Set("c", []byte("123456789"))
+	if _, ok := c.Get("b"); ok {
+		t.Error("the entry b should have been evicted")
+	}
+	if v, ok := c.Get("a"); !ok || string(v) != "123456789" {
+		t.Errorf("unexpected value for the entry a: %s", string(v))
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("the entry c should be in the cache")
+	}
+	if c.Size() != 20 {
+		t.Errorf("unexpected size: %d", c.Size())
+	}
+
+	c.Set("too big", []byte("123456789012345"))
+	if _, ok := c.Get("too big"); ok {
+		t.Error("the entry should not be stored")
+	}
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Error("the entry a should have been deleted")
+	}
+	if c.Size() != 10 {
+		t.Errorf("unexpected size: %d", c.Size())
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api2html_cache")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewDiskCache(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c.Set("http://example.com/a", []byte("response"))
+
+	c, err = NewDiskCache(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if v, ok := c.Get("http://example.com/a"); !ok || string(v) != "response" {
+		t.Errorf("unexpected value: %s", string(v))
+	}
+	c.Delete("http://example.com/a")
+	if _, ok := c.Get("http://example.com/a"); ok {
+		t.Error("the entry should have been deleted")
+	}
+
+	if _, err := NewDiskCache(""); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestNewCache(t *testing.T) {
+	if c, err := NewCache(CacheConfig{MaxBytes: 100}); err != nil {
+		t.Error(err)
+	} else if _, ok := c.(*LRUCache); !ok {
+		t.Errorf("unexpected cache: %T", c)
+	}
+	if _, err := NewCache(CacheConfig{Type: "unknown"}); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestCountingCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		fmt.Fprintln(w, "Hi")
+	}))
+	defer mockServer.Close()
+
+	name := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	c := RegisterCache(name, NewLRUCache(1024))
+	backend := NewBackend(NewCachedHTTPClient(c), mockServer.URL+string(urlPattern))
+	for i := 0; i < 3; i++ {
+		resp, err := backend(params, headers, nil)
+		if err != nil {
+			t.Errorf("Backend response error: %s", err.Error())
+			return
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	if stats := Caches()[name]; stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
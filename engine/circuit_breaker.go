@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The states of a CircuitBreaker
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+var circuitBreakers = &sync.Map{}
+
+// CircuitBreakers returns the state of all the registered circuit breakers, indexed by name
+func CircuitBreakers() map[string]CircuitBreakerState {
+	result := map[string]CircuitBreakerState{}
+	circuitBreakers.Range(func(k, v interface{}) bool {
+		result[k.(string)] = v.(*CircuitBreaker).State()
+		return true
+	})
+	return result
+}
+
+// NewCircuitBreaker creates a CircuitBreaker and registers it with the received name, so its
+// state can be monitored. The circuit opens after maxErrors consecutive failures (5 if not
+// positive) and it stays open for the given timeout
+func NewCircuitBreaker(name string, maxErrors int, timeout time.Duration) *CircuitBreaker {
+	if maxErrors <= 0 {
+		maxErrors = 5
+	}
+	cb := &CircuitBreaker{
+		name:      name,
+		maxErrors: maxErrors,
+		timeout:   timeout,
+		state:     CircuitClosed,
+		mutex:     &sync.Mutex{},
+	}
+	circuitBreakers.Store(name, cb)
+	return cb
+}
+
+// CircuitBreaker short-circuits the calls to a Backend after a number of consecutive failures
+type CircuitBreaker struct {
+	name      string
+	maxErrors int
+	timeout   time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	mutex     *sync.Mutex
+}
+
+// CircuitBreakerState is a snapshot of the state of a CircuitBreaker
+type CircuitBreakerState struct {
+	State    string    `json:"state"`
+	Failures int       `json:"consecutive_failures"`
+	OpenedAt time.Time `json:"opened_at"`
+}
+
+// State returns a snapshot of the state of the circuit breaker
+func (cb *CircuitBreaker) State() CircuitBreakerState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return CircuitBreakerState{cb.state, cb.failures, cb.openedAt}
+}
+
+// Backend decorates the received Backend, returning ErrCircuitOpen without calling it while the
+// circuit is open. Errors and responses with a 5xx status code are considered failures
+func (cb *CircuitBreaker) Backend(next Backend) Backend {
+	return func(params map[string]string, headers map[string]string, c *gin.Context) (*http.Response, error) {
+		if !cb.allow() {
+			return nil, ErrCircuitOpen
+		}
+		resp, err := next(params, headers, c)
+		cb.register(err == nil && resp.StatusCode < http.StatusInternalServerError)
+		return resp, err
+	}
+}
+
+func (cb *CircuitBreaker) allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.timeout {
+			return false
+		}
+		cb.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		return false
+	}
+	return true
+}
+
+func (cb *CircuitBreaker) register(ok bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if ok {
+		cb.state = CircuitClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.maxErrors {
+		cb.state = CircuitOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// fallbackBackend decorates the received Backend, returning the content of the file at the
+// given path when the circuit is open
+func fallbackBackend(next Backend, path string) Backend {
+	return func(params map[string]string, headers map[string]string, c *gin.Context) (*http.Response, error) {
+		resp, err := next(params, headers, c)
+		if err != ErrCircuitOpen {
+			return resp, err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: f}, nil
+	}
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	backendErr := fmt.Errorf("backendErr")
+	calls := 0
+	fail := true
+	backend := func(_ map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+		calls++
+		if fail {
+			return nil, backendErr
+		}
+		return &http.Response{StatusCode: 200}, nil
+	}
+	cb := NewCircuitBreaker("test-circuit-breaker", 2, 10*time.Millisecond)
+	subject := cb.Backend(backend)
+
+	for i, expected := range []error{backendErr, backendErr, ErrCircuitOpen, ErrCircuitOpen} {
+		if _, err := subject(params, headers, nil); err != expected {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+	if s := cb.State(); s.State != CircuitOpen || s.Failures != 2 {
+		t.Errorf("unexpected state: %v", s)
+	}
+	if s, ok := CircuitBreakers()["test-circuit-breaker"]; !ok || s.State != CircuitOpen {
+		t.Errorf("unexpected registered state: %v", s)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := subject(params, headers, nil); err != backendErr {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := subject(params, headers, nil); err != ErrCircuitOpen {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	fail = false
+	if resp, err := subject(params, headers, nil); err != nil || resp.StatusCode != 200 {
+		t.Errorf("unexpected response: %v, %v", resp, err)
+	}
+	if s := cb.State(); s.State != CircuitClosed || s.Failures != 0 {
+		t.Errorf("unexpected state: %v", s)
+	}
+	if calls != 4 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestCircuitBreaker_defaultMaxErrors(t *testing.T) {
+	backendErr := fmt.Errorf("backendErr")
+	backend := func(_ map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+		return nil, backendErr
+	}
+	cb := NewCircuitBreaker("test-circuit-breaker-default", 0, time.Minute)
+	subject := cb.Backend(backend)
+
+	for i := 0; i < 5; i++ {
+		if _, err := subject(params, headers, nil); err != backendErr {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+	if _, err := subject(params, headers, nil); err != ErrCircuitOpen {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFallbackBackend(t *testing.T) {
+	fileName := fmt.Sprintf("testFallback-%d", time.Now().Unix())
+	if err := ioutil.WriteFile(fileName, []byte(`{"a":"b"}`), 0666); err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.Remove(fileName)
+
+	subject := fallbackBackend(func(_ map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+		return nil, ErrCircuitOpen
+	}, fileName)
+	resp, err := subject(params, headers, nil)
+	if err != nil {
+		t.Error("unexpected error:", err.Error())
+		return
+	}
+	r := ResponseContext{}
+	if err := JSONDecoder(resp.Body, &r); err != nil {
+		t.Error("unexpected error:", err.Error())
+		return
+	}
+	resp.Body.Close()
+	if v, ok := r.Data["a"]; !ok || v.(string) != "b" {
+		t.Errorf("unexpected response: %v", r.Data)
+	}
+}
+
+func TestMonitoringHandler(t *testing.T) {
+	NewCircuitBreaker("test-monitoring", 1, time.Second)
+
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.GET("/__monitoring", MonitoringHandler)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/__monitoring", nil)
+	e.ServeHTTP(w, r)
+	if w.Result().StatusCode != 200 {
+		t.Errorf("unexpected status code: %d", w.Result().StatusCode)
+	}
+	var stats map[string]map[string]CircuitBreakerState
+	if err := json.NewDecoder(w.Result().Body).Decode(&stats); err != nil {
+		t.Error("unexpected error:", err.Error())
+		return
+	}
+	if s, ok := stats["circuit_breakers"]["test-monitoring"]; !ok || s.State != CircuitClosed {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NewCoalescer creates a Coalescer identifying the backend requests with the received RequestKeyFunc
+func NewCoalescer(key RequestKeyFunc) *Coalescer {
+	return &Coalescer{
+		key:   key,
+		calls: map[string]*coalescedCall{},
+		mutex: &sync.Mutex{},
+	}
+}
+
+// Coalescer collapses the concurrent identical backend requests, so they share a single in-flight
+// call and its decoded response
+type Coalescer struct {
+	key   RequestKeyFunc
+	calls map[string]*coalescedCall
+	mutex *sync.Mutex
+}
+
+type coalescedCall struct {
+	wg     *sync.WaitGroup
+	result ResponseContext
+	err    error
+}
+
+// Fetch decodes the backend response into the result using the received FetchFunc, unless there
+// is already an in-flight call for the same request. In that case, it waits for the in-flight
+// call and returns its results. A nil Coalescer just calls the FetchFunc
+func (co *Coalescer) Fetch(params map[string]string, headers map[string]string, c *gin.Context, result *ResponseContext, fetch FetchFunc) error {
+	if co == nil {
+		return fetch(c, result)
+	}
+
+	key := co.key(params, headers, c)
+	co.mutex.Lock()
+	call, ok := co.calls[key]
+	if !ok {
+		call = &coalescedCall{wg: &sync.WaitGroup{}}
+		call.wg.Add(1)
+		co.calls[key] = call
+	}
+	co.mutex.Unlock()
+
+	if ok {
+		call.wg.Wait()
+	} else {
+		co.do(key, call, c, fetch)
+	}
+
+	result.Data = call.result.Data
+	result.Array = call.result.Array
+	result.Value = call.result.Value
+	return call.err
+}
+
+func (co *Coalescer) do(key string, call *coalescedCall, c *gin.Context, fetch FetchFunc) {
+	defer func() {
+		co.mutex.Lock()
+		delete(co.calls, key)
+		co.mutex.Unlock()
+		call.wg.Done()
+	}()
+	call.err = fetch(c, &call.result)
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCoalescer(t *testing.T) {
+	backendErr := fmt.Errorf("backendErr")
+	var calls int32
+	var fetchErr error
+	fetch := func(_ *gin.Context, r *ResponseContext) error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		r.Data = map[string]interface{}{"a": true}
+		return fetchErr
+	}
+	subject := NewCoalescer(func(params map[string]string, _ map[string]string, _ *gin.Context) string {
+		return params["param"]
+	})
+	c := newTestContext()
+
+	for _, fetchErr = range []error{nil, backendErr} {
+		atomic.StoreInt32(&calls, 0)
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				r := ResponseContext{}
+				if err := subject.Fetch(params, headers, c, &r, fetch); err != fetchErr {
+					t.Errorf("#%d: unexpected error: %v", i, err)
+				}
+				if r.Data["a"] != true {
+					t.Errorf("#%d: unexpected data: %v", i, r.Data)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("unexpected number of calls: %d", n)
+		}
+	}
+
+	r := ResponseContext{}
+	subject.Fetch(map[string]string{"param": "other"}, headers, c, &r, fetch)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("unexpected number of calls: %d", n)
+	}
+}
+
+func TestCoalescer_nil(t *testing.T) {
+	var subject *Coalescer
+	r := ResponseContext{}
+	err := subject.Fetch(params, headers, nil, &r, func(_ *gin.Context, r *ResponseContext) error {
+		r.Data = map[string]interface{}{"a": true}
+		return nil
+	})
+	if err != nil {
+		t.Error("unexpected error:", err.Error())
+	}
+	if r.Data["a"] != true {
+		t.Errorf("unexpected data: %v", r.Data)
+	}
+}
@@ -44,6 +44,15 @@ func ParseConfig(r io.Reader) (Config, error) {
 	}
 	
 	for p, page := range cfg.Pages {
+		if page.Headers == nil {
+			cfg.Pages[p].Headers = cfg.Headers
+		}
+		if len(page.AllowedHosts) == 0 {
+			cfg.Pages[p].AllowedHosts = cfg.AllowedHosts
+		}
+		if page.DataFolder == "" {
+			cfg.Pages[p].DataFolder = cfg.DataFolder
+		}
 		if len(page.Extra) == 0 {
 			cfg.Pages[p].Extra = cfg.Extra
 			continue
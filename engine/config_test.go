@@ -155,3 +155,110 @@ func TestParseConfig_extra(t *testing.T) {
 		}
 	}
 }
+
+func TestParseConfig_headers(t *testing.T) {
+	configContent := `{
+	"headers": {
+		"forward": ["Authorization"],
+		"static": {"X-Api-Key": "key"}
+	},
+	"pages":[
+		{
+			"name": "page01",
+			"URLPattern": "/page-01",
+			"BackendURLPattern": "https://jsonplaceholder.typicode.com/users/1"
+		},
+		{
+			"name": "page02",
+			"URLPattern": "/page-02",
+			"BackendURLPattern": "https://jsonplaceholder.typicode.com/users/2",
+			"Headers": {
+				"forward": ["Accept-Language", "X-Tenant"],
+				"rename": {"X-Tenant": "X-Tenant-Id"}
+			}
+		}
+	]
+}`
+	c, err := ParseConfig(bytes.NewBufferString(configContent))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(c.Pages) != 2 {
+		t.Error("unexpected number of pages:", c.Pages)
+		return
+	}
+	if h := c.Pages[0].Headers; h == nil || len(h.Forward) != 1 || h.Static["X-Api-Key"] != "key" {
+		t.Errorf("unexpected headers for the first page: %v", h)
+	}
+	if h := c.Pages[1].Headers; h == nil || len(h.Forward) != 2 || h.Rename["X-Tenant"] != "X-Tenant-Id" || len(h.Static) != 0 {
+		t.Errorf("unexpected headers for the second page: %v", h)
+	}
+}
+
+func TestParseConfig_allowedHosts(t *testing.T) {
+	configContent := `{
+	"allowed_hosts": ["jsonplaceholder.typicode.com"],
+	"pages":[
+		{
+			"name": "page01",
+			"URLPattern": "/page-01",
+			"BackendURLPattern": "https://jsonplaceholder.typicode.com/users/1"
+		},
+		{
+			"name": "page02",
+			"URLPattern": "/page-02",
+			"BackendURLPattern": "https://api.example.com/users/2",
+			"AllowedHosts": ["api.example.com"]
+		}
+	]
+}`
+	c, err := ParseConfig(bytes.NewBufferString(configContent))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(c.Pages) != 2 {
+		t.Error("unexpected number of pages:", c.Pages)
+		return
+	}
+	if h := c.Pages[0].AllowedHosts; len(h) != 1 || h[0] != "jsonplaceholder.typicode.com" {
+		t.Errorf("unexpected allowed hosts for the first page: %v", h)
+	}
+	if h := c.Pages[1].AllowedHosts; len(h) != 1 || h[0] != "api.example.com" {
+		t.Errorf("unexpected allowed hosts for the second page: %v", h)
+	}
+}
+
+func TestParseConfig_localData(t *testing.T) {
+	configContent := `data_folder: ./fixtures
+pages:
+  - name: stores
+    URLPattern: /stores/:city
+    BackendURLPattern: file://stores/:city.json
+  - name: legal
+    URLPattern: /legal
+    DataFolder: ./legal
+    Data:
+      title: Legal notice
+      sections:
+        - Privacy
+        - Cookies
+`
+	c, err := ParseConfig(bytes.NewBufferString(configContent))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(c.Pages) != 2 {
+		t.Error("unexpected number of pages:", c.Pages)
+		return
+	}
+	if c.Pages[0].DataFolder != "./fixtures" || c.Pages[1].DataFolder != "./legal" {
+		t.Errorf("unexpected data folders: %s, %s", c.Pages[0].DataFolder, c.Pages[1].DataFolder)
+	}
+	data, ok := c.Pages[1].Data.(map[string]interface{})
+	if !ok || data["title"] != "Legal notice" {
+		t.Errorf("unexpected inline data: %v", c.Pages[1].Data)
+	}
+}
@@ -1,13 +1,125 @@
 package engine
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
 )
 
 // Decoder defines the signature for response decoder functions
 type Decoder func(io.Reader, *ResponseContext) error
 
+// DecoderFactory returns the decoder for the responses with an object at the root or, if isArray
+// is set, with an array of objects
+type DecoderFactory func(isArray bool) Decoder
+
+// AutoDecoder is the name of the decoder choosing the decoder by the Content-Type header of the
+// backend response
+const AutoDecoder = "auto"
+
+var (
+	decoders            = &sync.Map{}
+	contentTypeDecoders = &sync.Map{}
+)
+
+func init() {
+	RegisterDecoder("json", newDecoder, "application/json", "text/json", "+json")
+	RegisterDecoder("json-object", func(_ bool) Decoder { return JSONDecoder })
+	RegisterDecoder("json-array", func(_ bool) Decoder { return JSONArrayDecoder })
+	RegisterDecoder("graphql", func(_ bool) Decoder { return GraphQLDecoder })
+	RegisterDecoder("xml", newXMLDecoder, "application/xml", "text/xml", "+xml")
+	RegisterDecoder("yaml", newYAMLDecoder, "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml", "+yaml")
+	RegisterDecoder("csv", func(_ bool) Decoder { return CSVDecoder }, "text/csv")
+	RegisterDecoder("ndjson", func(_ bool) Decoder { return NDJSONDecoder }, "application/x-ndjson", "application/ndjson", "application/jsonl")
+}
+
+// RegisterDecoder registers the decoder factory with the received name, so the pages can select it,
+// and as the decoder for the received media types. Media types starting with "+" match the
+// structured syntax suffixes (ex: "+json" matches "application/hal+json")
+func RegisterDecoder(name string, f DecoderFactory, contentTypes ...string) {
+	decoders.Store(name, f)
+	for _, ct := range contentTypes {
+		contentTypeDecoders.Store(ct, f)
+	}
+}
+
+// NewDecoder returns the decoder registered with the received name. The AutoDecoder chooses the
+// decoder of every response by its Content-Type header
+func NewDecoder(name string, isArray bool) (Decoder, error) {
+	if name == AutoDecoder {
+		return ContentTypeDecoder(isArray), nil
+	}
+	f, ok := decoders.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("decoder not defined: %s", name)
+	}
+	return f.(DecoderFactory)(isArray), nil
+}
+
+// DecoderForContentType returns the decoder registered for the media type of the received
+// Content-Type header and a boolean signaling if there was one
+func DecoderForContentType(contentType string, isArray bool) (Decoder, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	if f, ok := contentTypeDecoders.Load(mediaType); ok {
+		return f.(DecoderFactory)(isArray), true
+	}
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		if f, ok := contentTypeDecoders.Load(mediaType[i:]); ok {
+			return f.(DecoderFactory)(isArray), true
+		}
+	}
+	return nil, false
+}
+
+// ContentTypeDecoder returns a decoder delegating on the decoder registered for the Content-Type
+// header of every backend response. The JSONValueDecoder is used for the unknown content types
+func ContentTypeDecoder(isArray bool) Decoder {
+	fallback := newDecoder(isArray)
+	return func(r io.Reader, c *ResponseContext) error {
+		body, ok := r.(*responseBody)
+		if !ok {
+			return fallback(r, c)
+		}
+		d, ok := DecoderForContentType(body.header.Get("Content-Type"), isArray)
+		if !ok {
+			return fallback(r, c)
+		}
+		return d(r, c)
+	}
+}
+
+// responseBody is the reader passed to the decoders, so they can access to the response headers
+type responseBody struct {
+	io.Reader
+	header http.Header
+}
+
+func newPageDecoder(name string, isArray bool) Decoder {
+	if name == "" {
+		return newDecoder(isArray)
+	}
+	d, err := NewDecoder(name, isArray)
+	if err != nil {
+		log.Println(err.Error())
+		return newDecoder(isArray)
+	}
+	return d
+}
+
 // JSONDecoder decodes the reader content and puts it into the Data property of the
 // injected ResponseContext
 func JSONDecoder(r io.Reader, c *ResponseContext) error {
@@ -33,3 +145,263 @@ func JSONArrayDecoder(r io.Reader, c *ResponseContext) error {
 	c.Array = target
 	return nil
 }
+
+// GraphQLDecoder decodes the data of the GraphQL response in the reader and puts it into the
+// Data property of the injected ResponseContext. It returns a GraphQLError if the response
+// contains errors
+func GraphQLDecoder(r io.Reader, c *ResponseContext) error {
+	var target struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&target); err != nil {
+		return err
+	}
+	if len(target.Errors) > 0 {
+		err := &GraphQLError{Messages: make([]string, len(target.Errors))}
+		for i, e := range target.Errors {
+			err.Messages[i] = e.Message
+		}
+		return err
+	}
+	if target.Data == nil {
+		return fmt.Errorf("graphql response without data")
+	}
+	c.Data = target.Data
+	return nil
+}
+
+// JSONValueDecoder decodes any JSON value in the reader and puts it into the Value property of the
+// injected ResponseContext. Objects are also put into the Data property and arrays of objects
+// into the Array property
+func JSONValueDecoder(r io.Reader, c *ResponseContext) error {
+	var target interface{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&target); err != nil {
+		return err
+	}
+	setResponseValue(c, target)
+	return nil
+}
+
+// setResponseValue puts the value into the Value property of the ResponseContext and, depending on
+// its shape, into the Data or the Array properties
+func setResponseValue(c *ResponseContext, value interface{}) {
+	c.Value = value
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c.Data = v
+	case []interface{}:
+		array := make([]map[string]interface{}, len(v))
+		for i, item := range v {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				return
+			}
+			array[i] = obj
+		}
+		c.Array = array
+	}
+}
+
+// newDecoder returns the default JSON decoder. The shape of the response is detected, so the
+// isArray flag is ignored
+func newDecoder(_ bool) Decoder {
+	return JSONValueDecoder
+}
+
+// XMLDecoder decodes the XML document in the reader and puts the content of its root element
+// into the Data property of the injected ResponseContext. The attributes and the child elements
+// are stored by name, the repeated child elements are stored as lists and the text of the
+// elements with attributes or children is stored under the "text" key
+func XMLDecoder(r io.Reader, c *ResponseContext) error {
+	d := xml.NewDecoder(r)
+	start, err := xmlRoot(d)
+	if err != nil {
+		return err
+	}
+	v, err := decodeXMLElement(d, start)
+	if err != nil {
+		return err
+	}
+	if data, ok := v.(map[string]interface{}); ok {
+		c.Data = data
+		return nil
+	}
+	c.Data = map[string]interface{}{"text": v}
+	return nil
+}
+
+// XMLArrayDecoder decodes the XML document in the reader and puts the child elements of its root
+// element into the Array property of the injected ResponseContext
+func XMLArrayDecoder(r io.Reader, c *ResponseContext) error {
+	d := xml.NewDecoder(r)
+	if _, err := xmlRoot(d); err != nil {
+		return err
+	}
+	target := []map[string]interface{}{}
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			v, err := decodeXMLElement(d, t)
+			if err != nil {
+				return err
+			}
+			item, ok := v.(map[string]interface{})
+			if !ok {
+				item = map[string]interface{}{"text": v}
+			}
+			target = append(target, item)
+		case xml.EndElement:
+			c.Array = target
+			return nil
+		}
+	}
+}
+
+func newXMLDecoder(isArray bool) Decoder {
+	if isArray {
+		return XMLArrayDecoder
+	}
+	return XMLDecoder
+}
+
+func xmlRoot(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := t.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+func decodeXMLElement(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	result := map[string]interface{}{}
+	for _, a := range start.Attr {
+		result[a.Name.Local] = a.Value
+	}
+	text := &bytes.Buffer{}
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			v, err := decodeXMLElement(d, t)
+			if err != nil {
+				return nil, err
+			}
+			switch prev := result[t.Name.Local].(type) {
+			case nil:
+				result[t.Name.Local] = v
+			case []interface{}:
+				result[t.Name.Local] = append(prev, v)
+			default:
+				result[t.Name.Local] = []interface{}{prev, v}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(result) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				result["text"] = s
+			}
+			return result, nil
+		}
+	}
+}
+
+// YAMLDecoder decodes the YAML document in the reader and puts it into the Data property of the
+// injected ResponseContext
+func YAMLDecoder(r io.Reader, c *ResponseContext) error {
+	return decodeYAML(r, c, JSONDecoder)
+}
+
+// YAMLArrayDecoder decodes the YAML document in the reader and puts it into the Array property of
+// the injected ResponseContext
+func YAMLArrayDecoder(r io.Reader, c *ResponseContext) error {
+	return decodeYAML(r, c, JSONArrayDecoder)
+}
+
+func newYAMLDecoder(isArray bool) Decoder {
+	if isArray {
+		return YAMLArrayDecoder
+	}
+	return YAMLDecoder
+}
+
+func decodeYAML(r io.Reader, c *ResponseContext, d Decoder) error {
+	y, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	j, err := yaml.YAMLToJSON(y)
+	if err != nil {
+		return err
+	}
+	return d(bytes.NewReader(j), c)
+}
+
+// CSVDecoder decodes the CSV content of the reader and puts its rows into the Array property of
+// the injected ResponseContext, using the values of the first row as keys
+func CSVDecoder(r io.Reader, c *ResponseContext) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("csv without header")
+	}
+	header := records[0]
+	target := make([]map[string]interface{}, len(records)-1)
+	for i, record := range records[1:] {
+		row := make(map[string]interface{}, len(header))
+		for j, k := range header {
+			if j < len(record) {
+				row[k] = record[j]
+			}
+		}
+		target[i] = row
+	}
+	c.Array = target
+	return nil
+}
+
+// NDJSONDecoder decodes the newline delimited JSON objects of the reader and puts them into the
+// Array property of the injected ResponseContext
+func NDJSONDecoder(r io.Reader, c *ResponseContext) error {
+	target := []map[string]interface{}{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	for {
+		var item map[string]interface{}
+		err := decoder.Decode(&item)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		target = append(target, item)
+	}
+	c.Array = target
+	return nil
+}
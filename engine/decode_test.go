@@ -2,6 +2,9 @@ package engine
 
 import (
 	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
 	"testing"
 )
 
@@ -32,3 +35,252 @@ func TestJSONArrayDecoder(t *testing.T) {
 		t.Errorf("unexpected obj value: %v", r.Data)
 	}
 }
+
+func TestGraphQLDecoder(t *testing.T) {
+	r := ResponseContext{}
+	if err := GraphQLDecoder(bytes.NewBufferString(`{"data":{"user":{"name":"Leanne"}}}`), &r); err != nil {
+		t.Error(err)
+		return
+	}
+	user, ok := r.Data["user"].(map[string]interface{})
+	if !ok || user["name"] != "Leanne" {
+		t.Errorf("unexpected obj value: %v", r.Data)
+	}
+}
+
+func TestGraphQLDecoder_ko(t *testing.T) {
+	r := ResponseContext{}
+	err := GraphQLDecoder(bytes.NewBufferString(`{"data":{"user":null},"errors":[{"message":"a"},{"message":"b"}]}`), &r)
+	gqlErr, ok := err.(*GraphQLError)
+	if !ok {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if gqlErr.Error() != "graphql errors: a; b" {
+		t.Errorf("unexpected error message: %s", gqlErr.Error())
+	}
+	if r.Data != nil {
+		t.Errorf("unexpected obj value: %v", r.Data)
+	}
+
+	for i, body := range []string{`{}`, `{"data":null}`, `[]`} {
+		if err := GraphQLDecoder(bytes.NewBufferString(body), &r); err == nil {
+			t.Errorf("#%d: error expected", i)
+		}
+	}
+}
+
+func TestXMLDecoder(t *testing.T) {
+	r := ResponseContext{}
+	body := `<?xml version="1.0"?>
+<user id="1">
+	<name>Leanne</name>
+	<tag>a</tag>
+	<tag>b</tag>
+	<address type="home">Kulas Light<city>Gwenborough</city></address>
+</user>`
+	if err := XMLDecoder(bytes.NewBufferString(body), &r); err != nil {
+		t.Error(err)
+		return
+	}
+	if r.Data["id"] != "1" || r.Data["name"] != "Leanne" {
+		t.Errorf("unexpected obj value: %v", r.Data)
+	}
+	if tags, ok := r.Data["tag"].([]interface{}); !ok || len(tags) != 2 || tags[1] != "b" {
+		t.Errorf("unexpected obj value: %v", r.Data)
+	}
+	address, ok := r.Data["address"].(map[string]interface{})
+	if !ok || address["type"] != "home" || address["text"] != "Kulas Light" || address["city"] != "Gwenborough" {
+		t.Errorf("unexpected obj value: %v", r.Data)
+	}
+
+	if err := XMLDecoder(bytes.NewBufferString(`<user><name>Leanne</user>`), &r); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestXMLArrayDecoder(t *testing.T) {
+	r := ResponseContext{}
+	body := `<users><user id="1"><name>Leanne</name></user><user id="2"><name>Ervin</name></user><user>anonymous</user></users>`
+	if err := XMLArrayDecoder(bytes.NewBufferString(body), &r); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(r.Array) != 3 {
+		t.Errorf("unexpected array value: %v", r.Array)
+		return
+	}
+	if r.Array[1]["id"] != "2" || r.Array[1]["name"] != "Ervin" || r.Array[2]["text"] != "anonymous" {
+		t.Errorf("unexpected array value: %v", r.Array)
+	}
+}
+
+func TestYAMLDecoder(t *testing.T) {
+	r := ResponseContext{}
+	if err := YAMLDecoder(bytes.NewBufferString("name: Leanne\nage: 42\n"), &r); err != nil {
+		t.Error(err)
+		return
+	}
+	if r.Data["name"] != "Leanne" || r.Data["age"].(json.Number).String() != "42" {
+		t.Errorf("unexpected obj value: %v", r.Data)
+	}
+
+	if err := YAMLArrayDecoder(bytes.NewBufferString("- name: Leanne\n- name: Ervin\n"), &r); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(r.Array) != 2 || r.Array[1]["name"] != "Ervin" {
+		t.Errorf("unexpected array value: %v", r.Array)
+	}
+}
+
+func TestCSVDecoder(t *testing.T) {
+	r := ResponseContext{}
+	if err := CSVDecoder(bytes.NewBufferString("id,name\n1,Leanne\n2,\"Ervin, Jr\"\n3\n"), &r); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(r.Array) != 3 {
+		t.Errorf("unexpected array value: %v", r.Array)
+		return
+	}
+	if r.Array[1]["id"] != "2" || r.Array[1]["name"] != "Ervin, Jr" {
+		t.Errorf("unexpected array value: %v", r.Array)
+	}
+	if _, ok := r.Array[2]["name"]; ok {
+		t.Errorf("unexpected array value: %v", r.Array)
+	}
+	if err := CSVDecoder(bytes.NewBufferString(""), &r); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestNDJSONDecoder(t *testing.T) {
+	r := ResponseContext{}
+	if err := NDJSONDecoder(bytes.NewBufferString("{\"id\":1}\n{\"id\":2}\n\n{\"id\":3}\n"), &r); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(r.Array) != 3 || r.Array[2]["id"].(json.Number).String() != "3" {
+		t.Errorf("unexpected array value: %v", r.Array)
+	}
+	if err := NDJSONDecoder(bytes.NewBufferString("{\"id\":1}\n[]\n"), &r); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestNewDecoder(t *testing.T) {
+	RegisterDecoder("test", func(isArray bool) Decoder {
+		return func(_ io.Reader, c *ResponseContext) error {
+			c.Data = map[string]interface{}{"isArray": isArray}
+			return nil
+		}
+	}, "application/x-test")
+
+	d, err := NewDecoder("test", true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r := ResponseContext{}
+	if err := d(bytes.NewBufferString(""), &r); err != nil || r.Data["isArray"] != true {
+		t.Errorf("unexpected result: %v, %v", r.Data, err)
+	}
+	if _, err := NewDecoder("unknown", false); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestDecoderForContentType(t *testing.T) {
+	for i, tc := range []struct {
+		contentType string
+		body        string
+		ok          bool
+	}{
+		{"application/json; charset=utf-8", `{"a":"b"}`, true},
+		{"application/hal+json", `{"a":"b"}`, true},
+		{"text/xml", `<root><a>b</a></root>`, true},
+		{"application/atom+xml", `<root><a>b</a></root>`, true},
+		{"application/x-yaml", "a: b", true},
+		{"text/html", "", false},
+		{"", "", false},
+	} {
+		d, ok := DecoderForContentType(tc.contentType, false)
+		if ok != tc.ok {
+			t.Errorf("#%d: unexpected result: %v", i, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		r := ResponseContext{}
+		if err := d(bytes.NewBufferString(tc.body), &r); err != nil || r.Data["a"] != "b" {
+			t.Errorf("#%d: unexpected result: %v, %v", i, r.Data, err)
+		}
+	}
+}
+
+func TestContentTypeDecoder(t *testing.T) {
+	subject := ContentTypeDecoder(true)
+	for i, tc := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/csv; charset=utf-8", "a\nb\n"},
+		{"application/x-ndjson", `{"a":"b"}`},
+		{"application/xml", "<root><item><a>b</a></item></root>"},
+		{"text/plain", `[{"a":"b"}]`},
+	} {
+		r := ResponseContext{}
+		body := &responseBody{bytes.NewBufferString(tc.body), http.Header{"Content-Type": []string{tc.contentType}}}
+		if err := subject(body, &r); err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if len(r.Array) != 1 || r.Array[0]["a"] != "b" {
+			t.Errorf("#%d: unexpected array value: %v", i, r.Array)
+		}
+	}
+
+	r := ResponseContext{}
+	if err := subject(bytes.NewBufferString(`[{"a":"b"}]`), &r); err != nil || len(r.Array) != 1 {
+		t.Errorf("unexpected result: %v, %v", r.Array, err)
+	}
+}
+
+func TestJSONValueDecoder(t *testing.T) {
+	for i, tc := range []struct {
+		body  string
+		data  bool
+		array int
+	}{
+		{`{"a":"b"}`, true, -1},
+		{`[{"a":"b"},{"a":"c"}]`, false, 2},
+		{`[]`, false, 0},
+		{`["a","b"]`, false, -1},
+		{`[{"a":"b"},1]`, false, -1},
+		{`"a"`, false, -1},
+		{`42`, false, -1},
+		{`null`, false, -1},
+	} {
+		r := ResponseContext{}
+		if err := JSONValueDecoder(bytes.NewBufferString(tc.body), &r); err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if (r.Data != nil) != tc.data {
+			t.Errorf("#%d: unexpected obj value: %v", i, r.Data)
+		}
+		if tc.array < 0 && r.Array != nil || tc.array >= 0 && (r.Array == nil || len(r.Array) != tc.array) {
+			t.Errorf("#%d: unexpected array value: %v", i, r.Array)
+		}
+		if v, _ := json.Marshal(r.Value); string(v) != tc.body {
+			t.Errorf("#%d: unexpected value: %s", i, string(v))
+		}
+	}
+
+	r := ResponseContext{}
+	if err := JSONValueDecoder(bytes.NewBufferString(`{"a":`), &r); err == nil {
+		t.Error("error expected")
+	}
+}
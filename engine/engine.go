@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	newrelic "github.com/newrelic/go-agent"
@@ -38,6 +39,41 @@ type Config struct {
 	Extra            map[string]interface{} `json:"extra"`
 	PublicFolder     *PublicFolder          `json:"public_folder"`
 	NewRelic         *NewRelic              `json:"newrelic"`
+	Headers          *HeaderPolicy          `json:"headers"`
+	MonitoringPath   string                 `json:"monitoring_path"`
+	Caches           map[string]CacheConfig `json:"caches"`
+	AllowedHosts     []string               `json:"allowed_hosts"`
+	DataFolder       string                 `json:"data_folder"`
+	Engines          map[string]string      `json:"engines"`
+	Partials         map[string]string      `json:"partials"`
+	Extends          map[string]string      `json:"extends"`
+	Helpers          HelperConfig           `json:"helpers"`
+}
+
+// HelperConfig configures the template helpers
+type HelperConfig struct {
+	// Locale is the language of the helpers (ex: "es"). Defaults to english
+	Locale string `json:"locale"`
+	// Timezone is the timezone of the formatted dates. Defaults to the local one
+	Timezone string `json:"timezone"`
+	// DateFormats maps names to go time layouts, replacing the DefaultDateFormats
+	DateFormats map[string]string `json:"date_formats"`
+	// Currencies is the list of extra currency codes to support
+	Currencies []string `json:"currencies"`
+	// TruncateLength is the number of characters kept by the truncate helper
+	TruncateLength int `json:"truncate_length"`
+	// BaseURL is the URL the relative URLs are resolved against by the URL helper
+	BaseURL string `json:"base_url"`
+}
+
+// CacheConfig defines a cache for the backend responses
+type CacheConfig struct {
+	// Type is the type of the cache: "memory" (default) or "disk"
+	Type string `json:"type"`
+	// MaxBytes limits the size of the memory caches. Unlimited if not set
+	MaxBytes int64 `json:"max_bytes"`
+	// Path is the folder where the disk caches store the responses
+	Path string `json:"path"`
 }
 
 // PublicFolder contains the info regarding the static contents to be served
@@ -63,6 +99,285 @@ type Page struct {
 	Header            string
 	IsArray           bool
 	Extra             map[string]interface{}
+	Backends          []BackendConfig
+	// QueryString is the list of query string params to forward to the backends. Use "*"
+	// for forwarding all of them
+	QueryString []string
+	// QueryParams maps query string params to placeholders in the backend URL patterns
+	QueryParams map[string]string
+	// Cookies maps cookies to placeholders in the backend URL patterns
+	Cookies map[string]string
+	// ExposedHeaders is the list of request headers to expose to the templates
+	ExposedHeaders []string
+	// Headers is the policy for the headers sent to the backends. If not set, the one defined
+	// at the root level of the config is used
+	Headers *HeaderPolicy
+	// Client defines the timeouts, retries and circuit breaker of the page backends
+	Client *ClientConfig
+	// Cache is the name of the cache to use for the page backends. The default one is used if
+	// not set
+	Cache string
+	// Stale enables serving stale decoded responses of the page backends
+	Stale *StaleConfig
+	// Coalesce collapses the concurrent identical requests to the page backends into a single call
+	Coalesce bool
+	// RawParams is the list of params inserted into the backend URL patterns without escaping
+	RawParams []string
+	// AllowedHosts is the list of hosts the page backends can point to. If not set, the one
+	// defined at the root level of the config is used
+	AllowedHosts []string
+	// StatusCodes maps the status codes returned by the main backend to page responses. By
+	// default, a 404 renders the 404 page and any 5xx triggers the error handler
+	StatusCodes map[int]StatusMapping
+	// OAuth2 enables the OAuth2 client credentials authentication for the page backends
+	OAuth2 *OAuth2Config
+	// Balancer spreads the requests to the page backends across several hosts
+	Balancer *BalancerConfig
+	// GraphQL turns the main backend into a GraphQL one, using the BackendURLPattern as endpoint
+	GraphQL *GraphQLConfig
+	// Data is an inline response for the page, decoded as the main backend responses when there
+	// is no BackendURLPattern
+	Data interface{}
+	// DataFolder is the folder containing the files of the file:// backends. If not set, the one
+	// defined at the root level of the config or DefaultDataFolder is used
+	DataFolder string
+	// Decoder is the name of the decoder of the main backend responses: "json" (default), "xml",
+	// "yaml", "csv", "ndjson", any other registered one or "auto" for choosing it by the
+	// Content-Type header of every response
+	Decoder string
+	// Transform is the list of steps reshaping the decoded response of the main backend before
+	// rendering it
+	Transform []TransformStep
+	// Chain is the list of backends to call in order after the main and the named backends. Their
+	// URL patterns can reference values of the previous responses, like :Data.author_id or
+	// :Backends.article.author.id
+	Chain []BackendConfig
+	// Schema is the JSON Schema the decoded response of the main backend is validated against
+	Schema *SchemaConfig
+	// Locale overrides the locale of the template helpers for this page
+	Locale string
+
+	helper *tplHelper
+}
+
+// SchemaConfig defines the validation of the decoded data of a page
+type SchemaConfig struct {
+	// Schema is the inline JSON Schema
+	Schema interface{} `json:"schema"`
+	// File is the path of the JSON Schema, used if there is no inline one
+	File string `json:"file"`
+	// OnViolation is the behaviour when the data violates the schema: "log" (default), "fail" or
+	// "fallback"
+	OnViolation string `json:"on_violation"`
+	// Template is the template rendered with the "fallback" behaviour
+	Template string `json:"template"`
+	// StatusCode is the status code of the fallback responses. Defaults to 200
+	StatusCode int `json:"status_code"`
+}
+
+// StatusMapping defines the page response for a status code returned by the backend
+type StatusMapping struct {
+	// Status is the status code of the page response. The backend one is used if not set
+	Status int `json:"status"`
+	// Template is the template to render with the decoded backend response. If not set, the
+	// redirections are sent to the Location and the rest of statuses are delegated to the error
+	// handlers
+	Template string `json:"template"`
+	// Location is the location of the redirections, where the placeholders are replaced with the
+	// params of the request (ex: "/products/:id"). The location of the backend is never exposed
+	Location string `json:"location"`
+}
+
+// StatusError is the error returned by the DynamicResponseGenerator when the backend responds with
+// a status code mapped to a custom page response
+type StatusError struct {
+	// StatusCode is the status code of the page response
+	StatusCode int
+	// Template is the name of the template to render, if any
+	Template string
+	// Location is the location of the redirection, if any
+	Location string
+}
+
+// Error implements the error interface
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("backend response mapped to the status code %d", e.StatusCode)
+}
+
+// HeaderPolicy defines the headers to add to the backend requests
+type HeaderPolicy struct {
+	// Forward is the list of request headers to copy into the backend requests
+	Forward []string `json:"forward"`
+	// Rename maps the names of the forwarded headers to the names to use in the backend requests
+	Rename map[string]string `json:"rename"`
+	// Static contains the headers to add to every backend request. References to environment
+	// variables in the values ($VAR or ${VAR}) are expanded
+	Static map[string]string `json:"static"`
+}
+
+// BackendConfig defines a named backend to be called concurrently with the rest of the backends
+// of a page. Its decoded response is stored in the Backends property of the ResponseContext under
+// the backend name
+type BackendConfig struct {
+	Name       string
+	URLPattern string
+	IsArray    bool
+	// Optional flags the backends whose failures should not break the page generation
+	Optional bool
+	// Client overrides the client config of the page for this backend
+	Client *ClientConfig
+	// Cache overrides the cache of the page for this backend
+	Cache string
+	// Stale overrides the stale config of the page for this backend
+	Stale *StaleConfig
+	// OAuth2 overrides the OAuth2 config of the page for this backend
+	OAuth2 *OAuth2Config
+	// Balancer overrides the balancer config of the page for this backend
+	Balancer *BalancerConfig
+	// GraphQL turns the backend into a GraphQL one, using the URLPattern as endpoint
+	GraphQL *GraphQLConfig
+	// Decoder is the name of the decoder of the backend responses
+	Decoder string
+	// Transform is the list of steps reshaping the decoded backend responses
+	Transform []TransformStep
+}
+
+// TransformStep is a step of the transformation of a decoded backend response. Every step defines
+// a single operation. The paths are dot separated keys, where a number selects an element of an
+// array and a key applied to an array is applied to all its elements
+type TransformStep struct {
+	// Select replaces the value with the one at the received path
+	Select string `json:"select"`
+	// Rename renames the keys of the objects
+	Rename map[string]string `json:"rename"`
+	// Pick keeps only the values at the received paths of the objects
+	Pick []string `json:"pick"`
+	// Flatten merges the fields of the object at the received path into its parent, prefixed with
+	// the path and an underscore
+	Flatten string `json:"flatten"`
+	// Sort sorts the arrays by the value at the received path
+	Sort string `json:"sort"`
+	// Desc sets a descending order for the Sort step
+	Desc bool `json:"desc"`
+	// Limit limits the number of elements of the arrays
+	Limit int `json:"limit"`
+	// Offset skips the first elements of the arrays
+	Offset int `json:"offset"`
+}
+
+// GraphQLConfig defines the query to send to a GraphQL backend. The data of the response is decoded
+// into the Data property of the ResponseContext and the errors are considered backend failures
+type GraphQLConfig struct {
+	// Query is the inline query
+	Query string `json:"query"`
+	// QueryFile is the path of a .graphql file with the query, used if there is no inline query
+	QueryFile string `json:"query_file"`
+	// Variables maps the names of the query variables to the params (path params, or query string
+	// params and cookies mapped to placeholders) providing their values
+	Variables map[string]string `json:"variables"`
+}
+
+// BalancerConfig defines the hosts serving a backend and how to spread the requests across them
+type BalancerConfig struct {
+	// Hosts is the list of base URLs of the replicas (ex: "http://10.0.0.1:8080"). They replace
+	// the scheme and host of the backend URL pattern
+	Hosts []string `json:"hosts"`
+	// Strategy is the host selection strategy: "round_robin" (default) or "least_inflight"
+	Strategy string `json:"strategy"`
+	// MaxFailures is the number of consecutive failures that ejects a host. Defaults to 3
+	MaxFailures int `json:"max_failures"`
+	// EjectionTime is the time an ejected host does not receive requests. Defaults to 30s
+	EjectionTime string `json:"ejection_time"`
+	// HealthCheck enables the active probing of the hosts
+	HealthCheck *HealthCheckConfig `json:"health_check"`
+}
+
+// HealthCheckConfig defines the active probing of the hosts of a balancer
+type HealthCheckConfig struct {
+	// Path is the path to request to every host. Any status code below 400 means healthy
+	Path string `json:"path"`
+	// Interval is the time between probes. Defaults to 10s
+	Interval string `json:"interval"`
+	// Timeout is the max duration of a probe. Defaults to 2s
+	Timeout string `json:"timeout"`
+}
+
+// OAuth2Config defines how to get the bearer tokens for the backend requests using the OAuth2
+// client credentials grant
+type OAuth2Config struct {
+	// TokenURL is the URL of the token endpoint
+	TokenURL string `json:"token_url"`
+	// ClientID is the id of the client. References to environment variables are expanded
+	ClientID string `json:"client_id"`
+	// ClientSecret is the secret of the client. References to environment variables are expanded
+	ClientSecret string `json:"client_secret"`
+	// Scopes is the list of scopes to request
+	Scopes []string `json:"scopes"`
+	// EndpointParams contains extra params to send to the token endpoint (ex: "audience")
+	EndpointParams map[string]string `json:"endpoint_params"`
+	// AuthStyle sets how the client credentials are sent: "header" (basic auth, default) or
+	// "params" (in the request body)
+	AuthStyle string `json:"auth_style"`
+	// Timeout is the max duration of the requests to the token endpoint. Defaults to 10s
+	Timeout string `json:"timeout"`
+}
+
+// StaleConfig defines when the last good decoded response of a backend request can be served
+// instead of calling the backend
+type StaleConfig struct {
+	// TTL is the time a decoded response is served without calling the backend
+	TTL string `json:"ttl"`
+	// StaleWhileRevalidate is the time after the TTL during which the decoded response is served
+	// while it is refreshed in background
+	StaleWhileRevalidate string `json:"stale_while_revalidate"`
+	// StaleIfError is the time after the TTL during which the decoded response is served if the
+	// backend fails
+	StaleIfError string `json:"stale_if_error"`
+	// MaxEntries limits the number of stored responses. Defaults to 10000
+	MaxEntries int `json:"max_entries"`
+}
+
+// ClientConfig defines the behaviour of the http client used for calling a backend
+type ClientConfig struct {
+	// ConnectTimeout is the max duration of the connection to the backend (ex: "500ms")
+	ConnectTimeout string `json:"connect_timeout"`
+	// Timeout is the max duration of every request to the backend, including reading its body
+	Timeout string `json:"timeout"`
+	// Retries is the number of times a failed request is retried. The requests of the GraphQL
+	// backends are posted, so they are never retried
+	Retries int `json:"retries"`
+	// RetryBackoff is the time to wait before the first retry. It is doubled after every attempt
+	RetryBackoff string `json:"retry_backoff"`
+	// CircuitBreaker enables a circuit breaker for the backend
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker"`
+	// TLS customizes the TLS settings of the connections to the backend
+	TLS *TLSConfig `json:"tls"`
+}
+
+// TLSConfig defines the TLS settings of the connections to a backend
+type TLSConfig struct {
+	// CAFile is the path of a PEM bundle with the certificate authorities to trust instead of
+	// the system ones
+	CAFile string `json:"ca_file"`
+	// CertFile is the path of the PEM client certificate to present to the backend
+	CertFile string `json:"cert_file"`
+	// KeyFile is the path of the PEM private key of the client certificate
+	KeyFile string `json:"key_file"`
+	// ServerName is the name used to verify the certificate of the backend, if it differs from
+	// the host of the backend URL
+	ServerName string `json:"server_name"`
+	// MinVersion is the minimum TLS version accepted: "1.0", "1.1", "1.2" (default) or "1.3"
+	MinVersion string `json:"min_version"`
+}
+
+// CircuitBreakerConfig defines the behaviour of a circuit breaker
+type CircuitBreakerConfig struct {
+	// MaxErrors is the number of consecutive failures that opens the circuit. Defaults to 5
+	MaxErrors int `json:"max_errors"`
+	// Timeout is the time the circuit stays open before letting a request reach the backend
+	Timeout string `json:"timeout"`
+	// Fallback is the path of a file to use as backend response while the circuit is open
+	Fallback string `json:"fallback"`
 }
 
 // New creates a gin engine with the default Factory
@@ -107,9 +422,55 @@ var ErrNoResponseGeneratorDefined = fmt.Errorf("no response generator defined")
 // ErrNoBackendDefined is the error returned when no Backend has been defined
 var ErrNoBackendDefined = fmt.Errorf("no backend defined")
 
+// ErrHostNotAllowed is the error returned by the backends when the URL of the request points to a
+// host not present in the list of allowed hosts
+var ErrHostNotAllowed = fmt.Errorf("backend host not allowed")
+
+// ErrPathNotAllowed is the error returned by the file backends when the path of the file is
+// outside of the data folder
+var ErrPathNotAllowed = fmt.Errorf("file path not allowed")
+
+// ErrCircuitOpen is the error returned by the backends with an open circuit breaker
+var ErrCircuitOpen = fmt.Errorf("circuit breaker open")
+
+// TokenError is the error returned by the backends when the token for the request can not be
+// obtained from the OAuth2 token endpoint
+type TokenError struct {
+	// TokenURL is the URL of the token endpoint
+	TokenURL string
+	// StatusCode is the status code returned by the token endpoint, if any
+	StatusCode int
+	// Err is the cause of the failure
+	Err error
+}
+
+// Error implements the error interface
+func (e *TokenError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("oauth2 token endpoint %s responded with status %d: %s", e.TokenURL, e.StatusCode, e.Err.Error())
+	}
+	return fmt.Sprintf("oauth2 token endpoint %s: %s", e.TokenURL, e.Err.Error())
+}
+
+// GraphQLError is the error returned by the decoder of the GraphQL backends when the response
+// contains errors
+type GraphQLError struct {
+	// Messages contains the messages of the errors
+	Messages []string
+}
+
+// Error implements the error interface
+func (e *GraphQLError) Error() string {
+	return fmt.Sprintf("graphql errors: %s", strings.Join(e.Messages, "; "))
+}
+
 // ErrNoRendererDefined is the error returned when no Renderer has been defined
 var ErrNoRendererDefined = fmt.Errorf("no rendered defined")
 
+// ErrNoLocation is the error returned when a redirection mapped without a template does not define
+// its location
+var ErrNoLocation = fmt.Errorf("redirection without location")
+
 // EmptyRenderer is the Renderer to be use if no other is defined
 var EmptyRenderer = ErrorRenderer{ErrNoRendererDefined}
 
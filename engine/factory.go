@@ -2,6 +2,7 @@ package engine
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -28,6 +29,8 @@ type Factory struct {
 	MustachePageFactory  func(*gin.Engine, *TemplateStore) MustachePageFactory
 	StaticHandlerFactory func(string) (StaticHandler, error)
 	ErrorHandlerFactory  func(string, int) (ErrorHandler, error)
+	// Helpers contains the custom template helpers to add to the built-in ones
+	Helpers map[string]HelperFunc
 }
 
 // New creates a gin engine with the received config and the injected factories
@@ -49,11 +52,33 @@ func (ef Factory) New(cfgPath string, devel bool) (*gin.Engine, error) {
 		newrelicApp = &nrapp
 	}
 
+	for name, cacheCfg := range cfg.Caches {
+		c, err := NewCache(cacheCfg)
+		if err != nil {
+			return nil, err
+		}
+		RegisterCache(name, c)
+	}
+
+	helpers := map[string]*tplHelper{}
+	for i, page := range cfg.Pages {
+		h, ok := helpers[page.Locale]
+		if !ok {
+			h = newTplHelper(cfg.Helpers, page.Locale, ef.Helpers)
+			helpers[page.Locale] = h
+		}
+		cfg.Pages[i].helper = h
+	}
+
 	templateStore := ef.TemplateStoreFactory()
 	e := ef.newGinEngine(cfg, devel)
 	pf := ef.MustachePageFactory(e, templateStore)
 	pf.Build(cfg)
 
+	if cfg.MonitoringPath != "" {
+		e.GET(cfg.MonitoringPath, MonitoringHandler)
+	}
+
 	if h, err := ef.StaticHandlerFactory("./static/404"); err == nil {
 		e.NoRoute(h.HandlerFunc())
 	} else {
@@ -63,38 +88,63 @@ func (ef Factory) New(cfgPath string, devel bool) (*gin.Engine, error) {
 
 	if devel {
 		e.PUT("/template/:templateName", func(c *gin.Context) {
-			file, err := c.FormFile("file")
+			file, data, err := uploadedFile(c)
 			if err != nil {
 				c.AbortWithError(http.StatusInternalServerError, err)
 				return
 			}
 
-			f, err := file.Open()
-			if err != nil {
+			templateName := c.Param("templateName")
+			if err := templateStore.Update(templateName, c.PostForm("engine"), file, data); err != nil {
 				c.AbortWithError(http.StatusInternalServerError, err)
 				return
 			}
 
-			defer f.Close()
+			c.String(http.StatusOK, fmt.Sprintf("'%s' uploaded and stored as [%s]!", templateName, file))
+		})
 
-			tmp, err := NewMustacheRenderer(f)
+		e.PUT("/partial/:partialName", func(c *gin.Context) {
+			file, data, err := uploadedFile(c)
 			if err != nil {
 				c.AbortWithError(http.StatusInternalServerError, err)
 				return
 			}
 
-			templateName := c.Param("templateName")
-			if err := templateStore.Set(templateName, tmp); err != nil {
+			partialName := c.Param("partialName")
+			if err := templateStore.SetPartial(partialName, string(data)); err != nil {
 				c.AbortWithError(http.StatusInternalServerError, err)
 				return
 			}
 
-			c.String(http.StatusOK, fmt.Sprintf("'%s' uploaded and stored as [%s]!", templateName, file.Filename))
+			c.String(http.StatusOK, fmt.Sprintf("partial '%s' uploaded and stored as [%s]!", partialName, file))
 		})
+
+		if len(cfg.Partials) > 0 {
+			if _, err := WatchPartials(templateStore, cfg.Partials); err != nil {
+				log.Println("watching the partials:", err.Error())
+			}
+		}
 	}
 	return e, nil
 }
 
+// uploadedFile returns the name and the contents of the file uploaded in the "file" form field
+func uploadedFile(c *gin.Context) (string, []byte, error) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return "", nil, err
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	return file.Filename, data, err
+}
+
 func (ef Factory) newGinEngine(cfg Config, devel bool) *gin.Engine {
 	if !devel {
 		gin.SetMode(gin.ReleaseMode)
@@ -131,6 +181,12 @@ func (ef Factory) setStatics(e *gin.Engine, cfg Config) {
 		e.StaticFile(fmt.Sprintf("/%s", fileName), fmt.Sprintf("./static/%s", fileName))
 	}
 
+	if h, err := ef.ErrorHandlerFactory("./static/404", http.StatusNotFound); err == nil {
+		e.Use(h.HandlerFunc())
+	} else {
+		e.Use(Default404ErrorHandler.HandlerFunc())
+	}
+
 	if h, err := ef.ErrorHandlerFactory("./static/500", http.StatusInternalServerError); err == nil {
 		e.Use(h.HandlerFunc())
 	} else {
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -83,6 +84,43 @@ func TestFactory_New_ok(t *testing.T) {
 	assertResponse(t, e, "/b", http.StatusNotFound, default404Tmpl)
 }
 
+func TestFactory_New_helpers(t *testing.T) {
+	if err := ioutil.WriteFile("test_tmpl", []byte("{{#Helper.Shout}}hi, {{Extra.name}}{{/Helper.Shout}} {{#Helper.Number}}{{Extra.n}}{{/Helper.Number}}"), 0644); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	defer os.Remove("test_tmpl")
+	extra := map[string]interface{}{"name": "stranger", "n": 1234.5}
+	ef := DefaultFactory
+	ef.Parser = func(_ string) (Config, error) {
+		return Config{
+			Pages: []Page{
+				{URLPattern: "/a", Template: "a", Extra: extra},
+				{URLPattern: "/b", Template: "a", Extra: extra, Locale: "es"},
+			},
+			Templates: map[string]string{"a": "test_tmpl"},
+			Helpers:   HelperConfig{Locale: "fr"},
+		}, nil
+	}
+	ef.TemplateStoreFactory = NewTemplateStore
+	ef.Helpers = map[string]HelperFunc{
+		"Shout": func(text string, render RenderFunc) (string, error) {
+			s, err := render(text)
+			return strings.ToUpper(s) + "!", err
+		},
+	}
+
+	e, err := ef.New("something", true)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	assertResponse(t, e, "/a", http.StatusOK, "HI, STRANGER! 1\u202f234,5")
+	assertResponse(t, e, "/b", http.StatusOK, "HI, STRANGER! 1.234,5")
+}
+
 func TestFactory_New_reloadTemplate(t *testing.T) {
 	if err := ioutil.WriteFile("test_tmpl", []byte("hi, {{Extra.name}}!"), 0644); err != nil {
 		t.Errorf("unexpected error: %s", err.Error())
@@ -154,6 +192,170 @@ func TestFactory_New_reloadTemplate(t *testing.T) {
 
 }
 
+func TestFactory_New_partials(t *testing.T) {
+	files := map[string]string{
+		"test_partial_tmpl":   "{{> greeting}}!",
+		"test_partial_lyt":    "<{{{content}}}>",
+		"test_partial_header": "hi, {{Extra.name}}",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+		defer os.Remove(name)
+	}
+	ef := DefaultFactory
+	ef.Parser = func(_ string) (Config, error) {
+		return Config{
+			Pages: []Page{
+				{
+					URLPattern: "/a",
+					Layout:     "b",
+					Template:   "a",
+					Extra: map[string]interface{}{
+						"name": "stranger",
+					},
+				},
+			},
+			Templates: map[string]string{"a": "test_partial_tmpl"},
+			Layouts:   map[string]string{"b": "test_partial_lyt"},
+			Partials:  map[string]string{"greeting": "test_partial_header"},
+		}, nil
+	}
+
+	e, err := ef.New("something", true)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	assertResponse(t, e, "/a", http.StatusOK, "<hi, stranger!>")
+
+	req, err := putTemplateForm("/partial/greeting", "bye, {{Extra.name}}")
+	if err != nil {
+		t.Errorf("Error creating PUT Form body: %s", err.Error())
+	}
+	resp := httptest.NewRecorder()
+	e.ServeHTTP(resp, req)
+	if statusCode := resp.Result().StatusCode; statusCode != http.StatusOK {
+		t.Errorf("[%s] unexpected status code: %d (%v)", "/partial/greeting", statusCode, resp.Result())
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	assertResponse(t, e, "/a", http.StatusOK, "<bye, stranger!>")
+}
+
+func TestFactory_New_extends(t *testing.T) {
+	files := map[string]string{
+		"test_extends_tmpl":    "{{$title}}{{Extra.name}}{{/title}}hi, {{Extra.name}}!",
+		"test_extends_section": "<main>{{{content}}}</main>",
+		"test_extends_base":    "<title>{{$title}}api2html{{/title}}</title>{{{content}}}",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+		defer os.Remove(name)
+	}
+	ef := DefaultFactory
+	ef.Parser = func(_ string) (Config, error) {
+		return Config{
+			Pages: []Page{
+				{
+					URLPattern: "/a",
+					Layout:     "section",
+					Template:   "a",
+					Extra: map[string]interface{}{
+						"name": "stranger",
+					},
+				},
+			},
+			Templates: map[string]string{"a": "test_extends_tmpl"},
+			Layouts: map[string]string{
+				"section": "test_extends_section",
+				"base":    "test_extends_base",
+			},
+			Extends: map[string]string{"section": "base"},
+		}, nil
+	}
+
+	e, err := ef.New("something", false)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	assertResponse(t, e, "/a", http.StatusOK, "<title>stranger</title><main>hi, stranger!</main>")
+
+	for _, extends := range []map[string]string{
+		{"section": "unknown"},
+		{"section": "base", "base": "section"},
+	} {
+		pf := NewMustachePageFactory(gin.New(), NewTemplateStore())
+		if err := pf.loadTemplates(Config{
+			Layouts: map[string]string{
+				"section": "test_extends_section",
+				"base":    "test_extends_base",
+			},
+			Extends: extends,
+		}); err == nil {
+			t.Errorf("expecting error with %v", extends)
+		}
+	}
+}
+
+func TestFactory_New_schemaFallback(t *testing.T) {
+	files := map[string]string{
+		"test_schema_tmpl":     "hi, {{Data.name}}!",
+		"test_schema_fallback": "sorry, {{Extra.name}}",
+		"test_schema_lyt":      "-{{{content}}}-",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+		defer os.Remove(name)
+	}
+	ef := DefaultFactory
+	ef.Parser = func(_ string) (Config, error) {
+		return Config{
+			Pages: []Page{
+				{
+					Name:       "schema_fallback",
+					URLPattern: "/a",
+					Layout:     "b",
+					Template:   "a",
+					Data:       map[string]interface{}{"name": 42},
+					Extra: map[string]interface{}{
+						"name": "stranger",
+					},
+					Schema: &SchemaConfig{
+						Schema: map[string]interface{}{
+							"type":       "object",
+							"properties": map[string]interface{}{"name": map[string]interface{}{"type": "string"}},
+						},
+						OnViolation: SchemaFallback,
+						Template:    "fallback",
+					},
+				},
+			},
+			Templates: map[string]string{"a": "test_schema_tmpl", "fallback": "test_schema_fallback"},
+			Layouts:   map[string]string{"b": "test_schema_lyt"},
+		}, nil
+	}
+
+	e, err := ef.New("something", false)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	assertResponse(t, e, "/a", http.StatusOK, "-sorry, stranger-")
+}
+
 func putTemplateForm(url, tmpl string) (*http.Request, error) {
 	buff := &bytes.Buffer{}
 	tmplWriter := multipart.NewWriter(buff)
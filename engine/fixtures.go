@@ -0,0 +1,212 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gregjones/httpcache"
+)
+
+// The fixture modes
+const (
+	// FixturesRecord stores every backend response in the fixtures folder
+	FixturesRecord = "record"
+	// FixturesReplay serves the backend responses from the fixtures folder, without sending any
+	// request to the backends
+	FixturesReplay = "replay"
+)
+
+// ErrFixtureNotFound is the error returned in replay mode when there is no fixture for a request
+var ErrFixtureNotFound = fmt.Errorf("fixture not found")
+
+var fixtures *Fixtures
+
+// UseFixtures makes all the backends created with NewBackend or NewBackendWithOptions record or
+// replay their responses with the received fixtures. A nil value disables them
+func UseFixtures(f *Fixtures) {
+	fixtures = f
+}
+
+// Fixtures is a folder of recorded backend responses, keyed by the URL of the requests
+type Fixtures struct {
+	Path string
+	Mode string
+}
+
+// NewFixtures creates a Fixtures for the received folder and mode, creating the folder when
+// recording
+func NewFixtures(path, mode string) (*Fixtures, error) {
+	switch mode {
+	case FixturesRecord:
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+	case FixturesReplay:
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown fixtures mode: %s", mode)
+	}
+	return &Fixtures{Path: path, Mode: mode}, nil
+}
+
+// Fixture is a recorded backend response, with all the values of its end-to-end headers. JSON
+// bodies are stored as they are, so they can be edited by hand, and the rest of them as text
+type Fixture struct {
+	Method     string          `json:"method"`
+	URL        string          `json:"url"`
+	StatusCode int             `json:"status_code"`
+	Header     http.Header     `json:"header,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	Text       string          `json:"text,omitempty"`
+}
+
+// Do returns the fixture of the request in replay mode. In record mode, it sends the request with
+// the received client and stores its response
+func (f *Fixtures) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if f.Mode == FixturesReplay {
+		return f.load(req)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err := f.store(req, resp, body); err != nil {
+		log.Println("recording the fixture of", req.URL.String(), err.Error())
+	}
+	return resp, nil
+}
+
+func (f *Fixtures) load(req *http.Request) (*http.Response, error) {
+	name, err := f.fileName(req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		log.Println("fixture not found for", req.Method, req.URL.String())
+		return nil, ErrFixtureNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	fixture := Fixture{}
+	if err := json.Unmarshal(b, &fixture); err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	for k, vs := range fixture.Header {
+		for _, v := range vs {
+			header.Add(k, v)
+		}
+	}
+	body := []byte(fixture.Text)
+	if len(fixture.Body) > 0 {
+		body = fixture.Body
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fixture.StatusCode, http.StatusText(fixture.StatusCode)),
+		StatusCode:    fixture.StatusCode,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (f *Fixtures) store(req *http.Request, resp *http.Response, body []byte) error {
+	name, err := f.fileName(req)
+	if err != nil {
+		return err
+	}
+	fixture := Fixture{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     http.Header{},
+	}
+	for k, vs := range resp.Header {
+		if !fixtureSkippedHeaders[k] {
+			fixture.Header[k] = append([]string{}, vs...)
+		}
+	}
+	if json.Valid(body) {
+		fixture.Body = body
+	} else {
+		fixture.Text = string(body)
+	}
+	b, err := json.MarshalIndent(fixture, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(f.Path, ".fixture")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// fixtureSkippedHeaders are the response headers not recorded: the hop-by-hop ones, the ones
+// depending on the stored body and the ones added by the http cache
+var fixtureSkippedHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Content-Length":      true,
+	"Content-Encoding":    true,
+	httpcache.XFromCache:  true,
+}
+
+var fixtureNameReplacer = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// fileName returns the path of the fixture of the request: a readable version of its URL followed
+// by the hash of its method, URL and body
+func (f *Fixtures) fileName(req *http.Request) (string, error) {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %s\n\n", req.Method, req.URL.String())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		io.Copy(h, body)
+		body.Close()
+	}
+	slug := strings.Trim(fixtureNameReplacer.ReplaceAllString(req.URL.Host+req.URL.Path, "-"), "-")
+	if len(slug) > 100 {
+		slug = slug[:100]
+	}
+	return filepath.Join(f.Path, fmt.Sprintf("%s-%x.json", slug, h.Sum(nil)[:6])), nil
+}
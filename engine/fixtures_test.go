@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api2html-fixtures")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixtures")
+
+	if _, err := NewFixtures(path, FixturesReplay); err == nil {
+		t.Error("error expected")
+	}
+	if _, err := NewFixtures(path, "unknown"); err == nil {
+		t.Error("error expected")
+	}
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/1":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"name":"Leanne","lang":"%s"}`, r.URL.Query().Get("lang"))
+		case "/old":
+			http.Redirect(w, r, "/users/1", http.StatusMovedPermanently)
+		case "/robots.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Add("Set-Cookie", "a=1")
+			w.Header().Add("Set-Cookie", "b=2")
+			fmt.Fprint(w, "User-agent: *")
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	URLPattern := mockServer.URL + "/:path"
+	client := &http.Client{CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	f, err := NewFixtures(path, FixturesRecord)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	UseFixtures(f)
+	defer UseFixtures(nil)
+	recorded := map[string]string{}
+	for _, p := range []string{"users/1?lang=en", "users/1?lang=es", "robots.txt", "old", "unknown"} {
+		resp, err := NewBackendWithOptions(client, URLPattern, BackendOptions{RawParams: []string{"path"}})(map[string]string{"path": p}, nil, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", p, err)
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		recorded[p] = fmt.Sprintf("%d %s %s %v %s", resp.StatusCode, resp.Header.Get("Content-Type"), resp.Header.Get("Location"), resp.Header["Set-Cookie"], string(body))
+	}
+	mockServer.Close()
+
+	files, _ := filepath.Glob(filepath.Join(path, "*.json"))
+	if len(files) != 5 {
+		t.Errorf("unexpected fixtures: %v", files)
+	}
+
+	f, err = NewFixtures(path, FixturesReplay)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	UseFixtures(f)
+	if recorded["old"] != "301 text/html; charset=utf-8 /users/1 [] <a href=\"/users/1\">Moved Permanently</a>.\n\n" {
+		t.Errorf("unexpected redirection: %s", recorded["old"])
+	}
+	if recorded["robots.txt"] != "200 text/plain  [a=1 b=2] User-agent: *" {
+		t.Errorf("unexpected response: %s", recorded["robots.txt"])
+	}
+	for p, expected := range recorded {
+		resp, err := NewBackendWithOptions(client, URLPattern, BackendOptions{RawParams: []string{"path"}})(map[string]string{"path": p}, nil, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", p, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if compact := bytes.NewBuffer(nil); json.Compact(compact, body) == nil {
+			body = compact.Bytes()
+		}
+		if result := fmt.Sprintf("%d %s %s %v %s", resp.StatusCode, resp.Header.Get("Content-Type"), resp.Header.Get("Location"), resp.Header["Set-Cookie"], string(body)); result != expected {
+			t.Errorf("%s: unexpected response. have: %s, want: %s", p, result, expected)
+		}
+	}
+
+	if _, err := NewBackend(http.DefaultClient, mockServer.URL+"/users/2")(nil, nil, nil); err != ErrFixtureNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -2,12 +2,16 @@ package engine
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"regexp"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gregjones/httpcache"
 	newrelic "github.com/newrelic/go-agent"
 	nrgin "github.com/newrelic/go-agent/_integrations/nrgin/v1"
 )
@@ -38,6 +42,9 @@ var DefaultHandlerConfig = HandlerConfig{
 // Default404StaticHandler is the default static handler for dealing with 404 errors
 var Default404StaticHandler = StaticHandler{[]byte(default404Tmpl)}
 
+// Default404ErrorHandler is the default error handler for dealing with 404 errors returned by the backends
+var Default404ErrorHandler = ErrorHandler{[]byte(default404Tmpl), http.StatusNotFound}
+
 // Default500StaticHandler is the default static handler for dealing with 500 errors
 var Default500StaticHandler = ErrorHandler{[]byte(default500Tmpl), http.StatusInternalServerError}
 
@@ -49,7 +56,7 @@ func NewHandlerConfig(page Page) HandlerConfig {
 	}
 	cacheTTL := fmt.Sprintf("public, max-age=%d", int(d.Seconds()))
 
-	if page.BackendURLPattern == "" {
+	if page.BackendURLPattern == "" && len(page.Backends) == 0 && len(page.Chain) == 0 && page.Data == nil {
 		rg := StaticResponseGenerator{page}
 		return HandlerConfig{
 			page,
@@ -59,11 +66,42 @@ func NewHandlerConfig(page Page) HandlerConfig {
 		}
 	}
 
-	decoder := JSONDecoder
-	if page.IsArray {
-		decoder = JSONArrayDecoder
+	rg := DynamicResponseGenerator{Page: page}
+	if page.BackendURLPattern != "" {
+		main := BackendConfig{
+			URLPattern: page.BackendURLPattern,
+			IsArray:    page.IsArray,
+			GraphQL:    page.GraphQL,
+			Decoder:    page.Decoder,
+			Transform:  page.Transform,
+		}
+		rg.Decoder = backendDecoder(main)
+		rg.Backend = newPageBackend(page, main, true)
+		rg.Stale = newStaleCache(page, main)
+		rg.Coalescer = newCoalescer(page, main)
+	} else if page.Data != nil {
+		rg.Decoder = backendDecoder(BackendConfig{Transform: page.Transform})
+		rg.Backend = NewInlineBackend(page.Data)
+	}
+	for _, b := range page.Backends {
+		rg.Backends = append(rg.Backends, newNamedBackend(page, b))
+	}
+	for _, b := range page.Chain {
+		nb := newNamedBackend(page, b)
+		nb.References = chainReferences(b.URLPattern)
+		rg.Chain = append(rg.Chain, nb)
+	}
+	if page.Schema != nil {
+		if rg.Schema, err = NewSchemaValidator(page.Name, *page.Schema); err != nil {
+			log.Println("creating the schema validator of", page.Name, ":", err.Error())
+			return HandlerConfig{
+				page,
+				DefaultHandlerConfig.Renderer,
+				func(_ *gin.Context) (ResponseContext, error) { return ResponseContext{}, err },
+				cacheTTL,
+			}
+		}
 	}
-	rg := DynamicResponseGenerator{page, CachedClient(page.BackendURLPattern), decoder}
 
 	return HandlerConfig{
 		page,
@@ -73,6 +111,210 @@ func NewHandlerConfig(page Page) HandlerConfig {
 	}
 }
 
+func newNamedBackend(page Page, cfg BackendConfig) NamedBackend {
+	return NamedBackend{
+		Name:      cfg.Name,
+		Backend:   newPageBackend(page, cfg, false),
+		Decoder:   backendDecoder(cfg),
+		Optional:  cfg.Optional,
+		Stale:     newStaleCache(page, cfg),
+		Coalescer: newCoalescer(page, cfg),
+	}
+}
+
+// chainReferences returns the placeholders of the URL pattern referencing values of the previous
+// responses
+func chainReferences(URLPattern string) []string {
+	refs := []string{}
+	for _, m := range chainReferencePattern.FindAllStringSubmatch(URLPattern, -1) {
+		refs = append(refs, m[1])
+	}
+	return refs
+}
+
+var chainReferencePattern = regexp.MustCompile(`:((?:Data|Backends)(?:\.[A-Za-z0-9_]+)+)`)
+
+// newPageBackend creates the backend of the page defined by the received config. The main backend
+// of the page does not follow the redirections mapped by the page
+func newPageBackend(page Page, cfg BackendConfig, main bool) Backend {
+	if isFilePattern(cfg.URLPattern) {
+		return NewFileBackend(page.DataFolder, cfg.URLPattern, page.RawParams)
+	}
+	opts, err := pageBackendOptions(page, cfg)
+	if err != nil {
+		log.Println("creating the backend of", backendName(page, cfg), ":", err.Error())
+		return errorBackend(err)
+	}
+	oauth2Cfg := cfg.OAuth2
+	if oauth2Cfg == nil {
+		oauth2Cfg = page.OAuth2
+	}
+	if oauth2Cfg != nil {
+		opts.Auth = NewClientCredentials(*oauth2Cfg)
+	}
+	cacheName := cfg.Cache
+	if cacheName == "" {
+		cacheName = page.Cache
+	}
+	cache, ok := lookupCache(cacheName)
+	if !ok {
+		if cacheName != "" {
+			log.Println("cache not defined", cacheName)
+		}
+		cache, _ = lookupCache(DefaultCache)
+	}
+	clientCfg := cfg.Client
+	if clientCfg == nil {
+		clientCfg = page.Client
+	}
+
+	client := NewCachedHTTPClient(cache)
+	if clientCfg != nil {
+		if client, err = NewHTTPClient(*clientCfg, cache); err != nil {
+			log.Println("creating the http client of", backendName(page, cfg), ":", err.Error())
+			return errorBackend(err)
+		}
+	}
+	if main && mapsRedirects(page) {
+		client.CheckRedirect = mappedRedirectPolicy(page)
+	}
+	if balancerCfg := pageBalancer(page, cfg); balancerCfg != nil {
+		transport := client.Transport.(*httpcache.Transport)
+		balancer, err := NewBalancer(backendName(page, cfg), *balancerCfg, transport.Transport)
+		if err != nil {
+			log.Println("creating the balancer of", backendName(page, cfg), ":", err.Error())
+			return errorBackend(err)
+		}
+		transport.Transport = balancer
+	}
+
+	b := NewBackendWithOptions(client, cfg.URLPattern, opts)
+	if clientCfg == nil {
+		return b
+	}
+	// the GraphQL queries are posted, so they are not retried
+	if clientCfg.Retries > 0 && cfg.GraphQL == nil {
+		b = RetryBackend(b, clientCfg.Retries, parseDuration(clientCfg.RetryBackoff, 100*time.Millisecond))
+	}
+	if cb := clientCfg.CircuitBreaker; cb != nil {
+		b = NewCircuitBreaker(backendName(page, cfg), cb.MaxErrors, parseDuration(cb.Timeout, time.Minute)).Backend(b)
+		if cb.Fallback != "" {
+			b = fallbackBackend(b, cb.Fallback)
+		}
+	}
+	return b
+}
+
+func backendDecoder(cfg BackendConfig) Decoder {
+	d := newPageDecoder(cfg.Decoder, cfg.IsArray)
+	if cfg.GraphQL != nil {
+		d = GraphQLDecoder
+	}
+	if len(cfg.Transform) == 0 {
+		return d
+	}
+	t, err := NewTransformer(cfg.Transform)
+	if err != nil {
+		log.Println("creating the transformer of", cfg.Name, ":", err.Error())
+		return func(_ io.Reader, _ *ResponseContext) error { return err }
+	}
+	return TransformDecoder(d, t)
+}
+
+// mapsRedirects returns true if the page maps any of the 3xx status codes
+func mapsRedirects(page Page) bool {
+	for code := range page.StatusCodes {
+		if code >= http.StatusMultipleChoices && code < http.StatusBadRequest {
+			return true
+		}
+	}
+	return false
+}
+
+// mappedRedirectPolicy returns a redirect policy stopping at the redirections mapped by the page,
+// so they are handled by the page instead of followed by the client
+func mappedRedirectPolicy(page Page) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if req.Response != nil {
+			if _, ok := page.StatusCodes[req.Response.StatusCode]; ok {
+				return http.ErrUseLastResponse
+			}
+		}
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return nil
+	}
+}
+
+func pageBalancer(page Page, cfg BackendConfig) *BalancerConfig {
+	if cfg.Balancer != nil {
+		return cfg.Balancer
+	}
+	return page.Balancer
+}
+
+func backendName(page Page, cfg BackendConfig) string {
+	if cfg.Name == "" {
+		return page.Name
+	}
+	return fmt.Sprintf("%s.%s", page.Name, cfg.Name)
+}
+
+func errorBackend(err error) Backend {
+	return func(_ map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+		return nil, err
+	}
+}
+
+func newStaleCache(page Page, cfg BackendConfig) *StaleCache {
+	staleCfg := cfg.Stale
+	if staleCfg == nil {
+		staleCfg = page.Stale
+	}
+	if staleCfg == nil {
+		return nil
+	}
+	opts, _ := pageBackendOptions(page, cfg)
+	return NewStaleCache(*staleCfg, NewRequestKeyFunc(cfg.URLPattern, opts))
+}
+
+func newCoalescer(page Page, cfg BackendConfig) *Coalescer {
+	if !page.Coalesce {
+		return nil
+	}
+	opts, _ := pageBackendOptions(page, cfg)
+	return NewCoalescer(NewRequestKeyFunc(cfg.URLPattern, opts))
+}
+
+func pageBackendOptions(page Page, cfg BackendConfig) (BackendOptions, error) {
+	opts := BackendOptions{
+		Query:        page.QueryString,
+		RawParams:    page.RawParams,
+		AllowedHosts: page.AllowedHosts,
+	}
+	if pageBalancer(page, cfg) != nil || isFilePattern(cfg.URLPattern) {
+		// the balanced requests are sent to the configured hosts, whatever the host of the pattern,
+		// and the file backends do not send requests at all
+		opts.AllowedHosts = nil
+	}
+	if page.Headers != nil {
+		opts.Headers = *page.Headers
+	}
+	if q := cfg.GraphQL; q != nil {
+		query := q.Query
+		if query == "" {
+			data, err := ioutil.ReadFile(q.QueryFile)
+			if err != nil {
+				return opts, err
+			}
+			query = string(data)
+		}
+		opts.GraphQL = &GraphQLQuery{Query: query, Variables: q.Variables}
+	}
+	return opts, nil
+}
+
 // NewHandler creates a Handler with the given configuration. The returned handler will be keeping itself
 // subscribed to the latest template updates using the given subscription channel, allowing hot
 // template reloads
@@ -84,8 +326,17 @@ func NewHandler(cfg HandlerConfig, subscriptionChan chan Subscription) *Handler
 		subscriptionChan,
 		cfg.ResponseGenerator,
 		cfg.CacheControl,
+		&sync.Map{},
 	}
 	go h.updateRenderer()
+	for _, mapping := range cfg.Page.StatusCodes {
+		if mapping.Template != "" {
+			go h.updateStatusRenderer(mapping.Template)
+		}
+	}
+	if s := cfg.Page.Schema; s != nil && s.Template != "" {
+		go h.updateStatusRenderer(s.Template)
+	}
 	return h
 }
 
@@ -102,19 +353,33 @@ type Handler struct {
 	Subscribe         chan Subscription
 	ResponseGenerator ResponseGenerator
 	CacheControl      string
+	statusRenderers   *sync.Map
 }
 
 func (h *Handler) updateRenderer() {
-	topic := h.Page.Template
-	if h.Page.Layout != "" {
-		topic = fmt.Sprintf("%s-:-%s", h.Page.Layout, h.Page.Template)
-	}
+	topic := rendererTopic(h.Page.Layout, h.Page.Template)
 	for {
 		h.Subscribe <- Subscription{topic, h.Input}
 		h.Renderer = <-h.Input
 	}
 }
 
+func (h *Handler) updateStatusRenderer(template string) {
+	topic := rendererTopic(h.Page.Layout, template)
+	input := make(chan Renderer)
+	for {
+		h.Subscribe <- Subscription{topic, input}
+		h.statusRenderers.Store(template, <-input)
+	}
+}
+
+func rendererTopic(layout, template string) string {
+	if layout == "" {
+		return template
+	}
+	return fmt.Sprintf("%s-:-%s", layout, template)
+}
+
 // HandlerFunc handles a gin request rendering the data returned by the response generator.
 // If the response generator does not return an error, it adds a Cache-Control header
 func (h *Handler) HandlerFunc(c *gin.Context) {
@@ -123,6 +388,10 @@ func (h *Handler) HandlerFunc(c *gin.Context) {
 	}
 	result, err := h.ResponseGenerator(c)
 	if err != nil {
+		if statusErr, ok := err.(*StatusError); ok {
+			h.handleStatus(c, result, statusErr)
+			return
+		}
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
@@ -136,6 +405,35 @@ func (h *Handler) HandlerFunc(c *gin.Context) {
 	}
 }
 
+func (h *Handler) handleStatus(c *gin.Context, result ResponseContext, err *StatusError) {
+	if err.Template == "" {
+		if err.StatusCode >= http.StatusMultipleChoices && err.StatusCode < http.StatusBadRequest {
+			if err.Location == "" {
+				c.AbortWithError(http.StatusInternalServerError, ErrNoLocation)
+				return
+			}
+			c.Header("Location", err.Location)
+			c.AbortWithStatus(err.StatusCode)
+			return
+		}
+		c.AbortWithError(err.StatusCode, err)
+		return
+	}
+
+	r, ok := h.statusRenderers.Load(err.Template)
+	if !ok {
+		c.AbortWithError(http.StatusInternalServerError, ErrNoRendererDefined)
+		return
+	}
+	if newrelicApp != nil {
+		defer newrelic.StartSegment(nrgin.Transaction(c), "Render").End()
+	}
+	c.Status(err.StatusCode)
+	if err := r.(Renderer).Render(c.Writer, result); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
+
 // NewStaticHandler creates a StaticHandler using the content of the received path
 func NewStaticHandler(path string) (StaticHandler, error) {
 	data, err := ioutil.ReadFile(path)
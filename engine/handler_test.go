@@ -1,16 +1,19 @@
 package engine
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gregjones/httpcache"
 )
 
 func TestNewStaticHandler(t *testing.T) {
@@ -274,3 +277,561 @@ func TestNewHandlerConfig_DynamicResponseGenerator(t *testing.T) {
 		t.Errorf("unexpected page config: %v", cfg.Page)
 	}
 }
+
+func TestNewHandlerConfig_namedBackends(t *testing.T) {
+	cfg := NewHandlerConfig(Page{
+		Name: "name",
+		Backends: []BackendConfig{
+			{Name: "reviews", URLPattern: "http://example.com/reviews", IsArray: true},
+			{Name: "stock", URLPattern: "http://example.com/stock", Optional: true},
+		},
+	})
+	if cfg.CacheControl != "public, max-age=3600" {
+		t.Errorf("unexpected cache control: %s", cfg.CacheControl)
+	}
+	if len(cfg.Page.Backends) != 2 {
+		t.Errorf("unexpected page config: %v", cfg.Page)
+	}
+	if cfg.ResponseGenerator == nil {
+		t.Error("nil response generator")
+	}
+}
+
+func TestNewHandlerConfig_caches(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		fmt.Fprintf(w, `{"path":"%s"}`, r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	suffix := time.Now().UnixNano()
+	pageCache := fmt.Sprintf("test-page-cache-%d", suffix)
+	backendCache := fmt.Sprintf("test-backend-cache-%d", suffix)
+	RegisterCache(pageCache, httpcache.NewMemoryCache())
+	RegisterCache(backendCache, httpcache.NewMemoryCache())
+
+	generate := func(cfg HandlerConfig) {
+		for i := 0; i < 2; i++ {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request, _ = http.NewRequest("GET", "/", nil)
+			if _, err := cfg.ResponseGenerator(c); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	generate(NewHandlerConfig(Page{
+		Name:              "caches",
+		BackendURLPattern: fmt.Sprintf("%s/page/%d", mockServer.URL, suffix),
+		Cache:             pageCache,
+		Backends: []BackendConfig{
+			{Name: "named", URLPattern: fmt.Sprintf("%s/named/%d", mockServer.URL, suffix), Cache: backendCache},
+		},
+	}))
+	stats := Caches()
+	if s := stats[pageCache]; s.Hits != 1 || s.Misses != 1 {
+		t.Errorf("unexpected page cache stats: %v", s)
+	}
+	if s := stats[backendCache]; s.Hits != 1 || s.Misses != 1 {
+		t.Errorf("unexpected backend cache stats: %v", s)
+	}
+
+	before := Caches()[DefaultCache]
+	generate(NewHandlerConfig(Page{
+		Name:              "undefined-cache",
+		BackendURLPattern: fmt.Sprintf("%s/undefined/%d", mockServer.URL, suffix),
+		Cache:             "undefined",
+	}))
+	after := Caches()[DefaultCache]
+	if after.Hits-before.Hits != 1 || after.Misses-before.Misses != 1 {
+		t.Errorf("unexpected default cache stats: %v, %v", before, after)
+	}
+	if s := Caches()[pageCache]; s.Hits != 1 || s.Misses != 1 {
+		t.Errorf("unexpected page cache stats: %v", s)
+	}
+}
+
+func TestHandler_statusErrors(t *testing.T) {
+	for i, tc := range []struct {
+		err      *StatusError
+		status   int
+		body     string
+		location string
+	}{
+		{&StatusError{StatusCode: 404}, 404, "not found", ""},
+		{&StatusError{StatusCode: 410}, 410, "", ""},
+		{&StatusError{StatusCode: 301, Location: "/new"}, 301, "", "/new"},
+		{&StatusError{StatusCode: 404, Template: "not_found"}, 404, "custom not found", ""},
+		{&StatusError{StatusCode: 404, Template: "unknown"}, 500, "", ""},
+	} {
+		cfg := HandlerConfig{
+			Renderer: EmptyRenderer,
+			ResponseGenerator: func(_ *gin.Context) (ResponseContext, error) {
+				return ResponseContext{}, tc.err
+			},
+			Page: Page{
+				Template:    "name",
+				StatusCodes: map[int]StatusMapping{404: {Template: "not_found"}},
+			},
+		}
+		subscriptionChan := make(chan Subscription)
+		h := NewHandler(cfg, subscriptionChan)
+		for j := 0; j < 2; j++ {
+			subscription := <-subscriptionChan
+			if subscription.Name != "not_found" {
+				continue
+			}
+			subscription.In <- RendererFunc(func(w io.Writer, v interface{}) error {
+				_, err := w.Write([]byte("custom not found"))
+				return err
+			})
+			<-subscriptionChan
+		}
+
+		gin.SetMode(gin.TestMode)
+		engine := gin.New()
+		errorHandler := ErrorHandler{[]byte("not found"), 404}
+		engine.Use(errorHandler.HandlerFunc())
+		engine.GET("/", h.HandlerFunc)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		engine.ServeHTTP(w, req)
+
+		if w.Result().StatusCode != tc.status {
+			t.Errorf("#%d: unexpected status code: %d", i, w.Result().StatusCode)
+		}
+		if w.Result().Header.Get("Location") != tc.location {
+			t.Errorf("#%d: unexpected location: %s", i, w.Result().Header.Get("Location"))
+		}
+		res, _ := ioutil.ReadAll(w.Result().Body)
+		w.Result().Body.Close()
+		if string(res) != tc.body {
+			t.Errorf("#%d: unexpected response content: %s", i, string(res))
+		}
+	}
+}
+
+func TestNewHandlerConfig_redirects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/temporary":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/new":
+			fmt.Fprint(w, `{"name":"new"}`)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer mockServer.Close()
+
+	for i, tc := range []struct {
+		backend     string
+		statusCodes map[int]StatusMapping
+		status      int
+		body        string
+		location    string
+	}{
+		{"/old", map[int]StatusMapping{301: {Location: "/moved"}}, http.StatusMovedPermanently, "", "/moved"},
+		{"/old", map[int]StatusMapping{301: {}}, http.StatusInternalServerError, "", ""},
+		{"/old", nil, http.StatusOK, "hi, new new", ""},
+		{"/temporary", map[int]StatusMapping{301: {Location: "/moved"}}, http.StatusOK, "hi, new new", ""},
+	} {
+		cfg := NewHandlerConfig(Page{
+			Name:              "redirects",
+			Template:          "name",
+			BackendURLPattern: mockServer.URL + tc.backend,
+			Backends:          []BackendConfig{{Name: "named", URLPattern: mockServer.URL + "/old"}},
+			StatusCodes:       tc.statusCodes,
+		})
+		cfg.Renderer = RendererFunc(func(w io.Writer, v interface{}) error {
+			result := v.(ResponseContext)
+			named, _ := result.Backends["named"].(map[string]interface{})
+			_, err := fmt.Fprintf(w, "hi, %v %v", result.Data["name"], named["name"])
+			return err
+		})
+		subscriptionChan := make(chan Subscription)
+		h := NewHandler(cfg, subscriptionChan)
+		<-subscriptionChan
+
+		engine := gin.New()
+		engine.GET("/", h.HandlerFunc)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		engine.ServeHTTP(w, req)
+
+		if w.Result().StatusCode != tc.status {
+			t.Errorf("#%d: unexpected status code: %d", i, w.Result().StatusCode)
+		}
+		if w.Result().Header.Get("Location") != tc.location {
+			t.Errorf("#%d: unexpected location: %s", i, w.Result().Header.Get("Location"))
+		}
+		res, _ := ioutil.ReadAll(w.Result().Body)
+		w.Result().Body.Close()
+		if string(res) != tc.body {
+			t.Errorf("#%d: unexpected response content: %s", i, string(res))
+		}
+	}
+}
+
+func TestNewHandlerConfig_retries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	for i, tc := range []struct {
+		graphQL *GraphQLConfig
+		calls   int
+	}{
+		{nil, 3},
+		{&GraphQLConfig{Query: "{ user { name } }"}, 1},
+	} {
+		calls = 0
+		cfg := NewHandlerConfig(Page{
+			Name:              fmt.Sprintf("retries-%d", i),
+			BackendURLPattern: mockServer.URL,
+			Client:            &ClientConfig{Retries: 2, RetryBackoff: "1ms"},
+			GraphQL:           tc.graphQL,
+		})
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/", nil)
+		if _, err := cfg.ResponseGenerator(c); err == nil {
+			t.Errorf("#%d: error expected", i)
+		}
+		if calls != tc.calls {
+			t.Errorf("#%d: unexpected number of calls: %d", i, calls)
+		}
+	}
+}
+
+func TestHandler_tokenEndpointDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"server_error"}`, http.StatusInternalServerError)
+	}))
+	defer tokenServer.Close()
+	backendCalls := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendCalls++
+		fmt.Fprintln(w, "{}")
+	}))
+	defer mockServer.Close()
+
+	cfg := NewHandlerConfig(Page{
+		Name:              "name",
+		Template:          "name",
+		BackendURLPattern: mockServer.URL,
+		OAuth2:            &OAuth2Config{TokenURL: tokenServer.URL, ClientID: "client"},
+	})
+	subscriptionChan := make(chan Subscription)
+	h := NewHandler(cfg, subscriptionChan)
+	subscription := <-subscriptionChan
+	subscription.In <- RendererFunc(func(w io.Writer, v interface{}) error {
+		_, err := w.Write([]byte("rendered"))
+		return err
+	})
+	<-subscriptionChan
+
+	engine := gin.New()
+	errorHandler := ErrorHandler{[]byte("error page"), http.StatusInternalServerError}
+	engine.Use(errorHandler.HandlerFunc())
+	engine.GET("/", h.HandlerFunc)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	engine.ServeHTTP(w, req)
+
+	if w.Result().StatusCode != http.StatusInternalServerError {
+		t.Errorf("unexpected status code: %d", w.Result().StatusCode)
+	}
+	res, _ := ioutil.ReadAll(w.Result().Body)
+	w.Result().Body.Close()
+	if string(res) != "error page" {
+		t.Errorf("unexpected response content: %s", string(res))
+	}
+	if backendCalls != 0 {
+		t.Errorf("unexpected number of backend calls: %d", backendCalls)
+	}
+}
+
+func TestNewHandlerConfig_badTLSConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := NewHandlerConfig(Page{
+		Name:              "name",
+		BackendURLPattern: "https://example.com",
+		Client:            &ClientConfig{TLS: &TLSConfig{CAFile: "unknown.pem"}},
+	})
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+	if _, err := cfg.ResponseGenerator(c); err == nil {
+		t.Error("error expected")
+	} else if !os.IsNotExist(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewHandlerConfig_graphQL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		var body struct {
+			Query     string            `json:"query"`
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+			return
+		}
+		if body.Variables["lang"] != "es" {
+			t.Errorf("unexpected variables: %v", body.Variables)
+		}
+		switch body.Variables["id"] {
+		case "1":
+			if body.Query != "query User($id: ID!) { user(id: $id) { name } }" {
+				t.Errorf("unexpected query: %s", body.Query)
+			}
+			fmt.Fprint(w, `{"data":{"user":{"name":"Leanne"}}}`)
+		case "2":
+			fmt.Fprint(w, `{"data":{"user":null},"errors":[{"message":"user not found"}]}`)
+		default:
+			t.Errorf("unexpected variables: %v", body.Variables)
+		}
+	}))
+	defer mockServer.Close()
+
+	dir, err := ioutil.TempDir("", "api2html-graphql")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	queryFile := filepath.Join(dir, "user.graphql")
+	if err := ioutil.WriteFile(queryFile, []byte("query User($id: ID!) { user(id: $id) { name } }"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cfg := NewHandlerConfig(Page{
+		Name:              "graphql",
+		BackendURLPattern: mockServer.URL + "/graphql",
+		QueryParams:       map[string]string{"lang": "language"},
+		GraphQL: &GraphQLConfig{
+			QueryFile: queryFile,
+			Variables: map[string]string{"id": "id", "lang": "language", "missing": "unknown"},
+		},
+	})
+
+	for i, tc := range []struct {
+		id  string
+		err bool
+	}{
+		{"1", false},
+		{"2", true},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/users/"+tc.id+"?lang=es", nil)
+		c.Params = gin.Params{{Key: "id", Value: tc.id}}
+		result, err := cfg.ResponseGenerator(c)
+		if tc.err {
+			if _, ok := err.(*GraphQLError); !ok {
+				t.Errorf("#%d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		user, ok := result.Data["user"].(map[string]interface{})
+		if !ok || user["name"] != "Leanne" {
+			t.Errorf("#%d: unexpected result: %v", i, result.Data)
+		}
+	}
+
+	cfg = NewHandlerConfig(Page{
+		Name:              "graphql",
+		BackendURLPattern: mockServer.URL + "/graphql",
+		GraphQL:           &GraphQLConfig{QueryFile: filepath.Join(dir, "unknown.graphql")},
+	})
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+	if _, err := cfg.ResponseGenerator(c); !os.IsNotExist(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewHandlerConfig_decoders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user":
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<user><name>Leanne</name></user>`)
+		case "/stores":
+			fmt.Fprint(w, "city\nMadrid\nBilbao\n")
+		}
+	}))
+	defer mockServer.Close()
+
+	cfg := NewHandlerConfig(Page{
+		Name:              "decoders",
+		BackendURLPattern: mockServer.URL + "/user",
+		Decoder:           AutoDecoder,
+		Backends: []BackendConfig{
+			{Name: "stores", URLPattern: mockServer.URL + "/stores", Decoder: "csv"},
+		},
+	})
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+	result, err := cfg.ResponseGenerator(c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Data["name"] != "Leanne" {
+		t.Errorf("unexpected data: %v", result.Data)
+	}
+	if stores, ok := result.Backends["stores"].([]map[string]interface{}); !ok || len(stores) != 2 || stores[1]["city"] != "Bilbao" {
+		t.Errorf("unexpected backends: %v", result.Backends)
+	}
+}
+
+func TestNewHandlerConfig_anyJSONValue(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users":
+			fmt.Fprint(w, `[{"name":"Leanne"},{"name":"Ervin"}]`)
+		case "/tags":
+			fmt.Fprint(w, `["a","b"]`)
+		case "/count":
+			fmt.Fprint(w, `42`)
+		}
+	}))
+	defer mockServer.Close()
+
+	cfg := NewHandlerConfig(Page{
+		Name:              "values",
+		BackendURLPattern: mockServer.URL + "/users",
+		Backends: []BackendConfig{
+			{Name: "tags", URLPattern: mockServer.URL + "/tags"},
+			{Name: "count", URLPattern: mockServer.URL + "/count"},
+		},
+	})
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+	result, err := cfg.ResponseGenerator(c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(result.Array) != 2 || result.Array[1]["name"] != "Ervin" {
+		t.Errorf("unexpected array: %v", result.Array)
+	}
+	if tags, ok := result.Backends["tags"].([]interface{}); !ok || len(tags) != 2 || tags[0] != "a" {
+		t.Errorf("unexpected backends: %v", result.Backends)
+	}
+	if count, ok := result.Backends["count"].(json.Number); !ok || count.String() != "42" {
+		t.Errorf("unexpected backends: %v", result.Backends)
+	}
+}
+
+func TestNewHandlerConfig_chain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/articles/1":
+			fmt.Fprint(w, `{"title":"Hello","author_id":42,"tags":["a b"]}`)
+		case "/articles/2":
+			fmt.Fprint(w, `{"title":"Anonymous"}`)
+		case "/users/42":
+			fmt.Fprint(w, `{"name":"Leanne","company":{"id":"acme"}}`)
+		case "/companies/acme":
+			fmt.Fprint(w, `{"name":"Acme"}`)
+		case "/tags/a%20b":
+			fmt.Fprint(w, `[{"count":3}]`)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer mockServer.Close()
+
+	page := Page{
+		Name:              "chain",
+		BackendURLPattern: mockServer.URL + "/articles/:id",
+		Chain: []BackendConfig{
+			{Name: "author", URLPattern: mockServer.URL + "/users/:Data.author_id"},
+			{Name: "company", URLPattern: mockServer.URL + "/companies/:Backends.author.company.id"},
+			{Name: "tag", URLPattern: mockServer.URL + "/tags/:Data.tags.0", Optional: true},
+		},
+	}
+	cfg := NewHandlerConfig(page)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/articles/1", nil)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	result, err := cfg.ResponseGenerator(c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Data["title"] != "Hello" {
+		t.Errorf("unexpected data: %v", result.Data)
+	}
+	if author, ok := result.Backends["author"].(map[string]interface{}); !ok || author["name"] != "Leanne" {
+		t.Errorf("unexpected backends: %v", result.Backends)
+	}
+	if company, ok := result.Backends["company"].(map[string]interface{}); !ok || company["name"] != "Acme" {
+		t.Errorf("unexpected backends: %v", result.Backends)
+	}
+	if tag, ok := result.Backends["tag"].([]map[string]interface{}); !ok || len(tag) != 1 {
+		t.Errorf("unexpected backends: %v", result.Backends)
+	}
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/articles/2", nil)
+	c.Params = gin.Params{{Key: "id", Value: "2"}}
+	if _, err = cfg.ResponseGenerator(c); err == nil || err.Error() != "backend author: missing value for Data.author_id" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	page.Chain = []BackendConfig{
+		{Name: "missing", URLPattern: mockServer.URL + "/users/:Data.title"},
+		{Name: "author", URLPattern: mockServer.URL + "/users/:Data.author_id"},
+	}
+	cfg = NewHandlerConfig(page)
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/articles/1", nil)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	if result, err = cfg.ResponseGenerator(c); err == nil {
+		t.Error("error expected")
+	}
+	if _, ok := result.Backends["author"]; ok {
+		t.Errorf("the chain was not aborted: %v", result.Backends)
+	}
+}
+
+func TestChainReferences(t *testing.T) {
+	refs := chainReferences("http://example.com/:id/:Data.author_id/:Backends.author.company.id?x=:Data.tags.0&y=:Database")
+	expected := []string{"Data.author_id", "Backends.author.company.id", "Data.tags.0"}
+	if len(refs) != len(expected) {
+		t.Errorf("unexpected references: %v", refs)
+		return
+	}
+	for i, ref := range refs {
+		if ref != expected[i] {
+			t.Errorf("#%d: unexpected reference: %s", i, ref)
+		}
+	}
+}
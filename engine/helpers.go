@@ -0,0 +1,511 @@
+package engine
+
+import (
+	"html"
+	"log"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// RenderFunc renders a template text with the context of the template being rendered
+type RenderFunc func(text string) (string, error)
+
+// HelperFunc is a template helper used as a mustache lambda: it receives the raw text of the
+// section and a function for rendering it and it returns the content to write, which is not
+// escaped
+type HelperFunc func(text string, render RenderFunc) (string, error)
+
+// DefaultDateFormats are the date formats available as Helper.Date.<name> if the config does not
+// redefine them
+var DefaultDateFormats = map[string]string{
+	"short":    "2 Jan 2006",
+	"long":     "Monday, 2 January 2006",
+	"time":     "15:04",
+	"datetime": "2 Jan 2006 15:04",
+	"iso":      "2006-01-02",
+}
+
+// DefaultTruncateLength is the number of characters kept by Helper.Truncate if the config does
+// not define another one
+const DefaultTruncateLength = 100
+
+var defaultHelper = newTplHelper(HelperConfig{}, "", nil)
+
+// tplHelper is the set of helpers exposed to the templates as Helper
+type tplHelper map[string]interface{}
+
+func (tplHelper) Now() string {
+	return time.Now().String()
+}
+
+// newTplHelper creates the helpers for the received config and locale (the one of the config if
+// empty), adding the custom ones
+func newTplHelper(cfg HelperConfig, locale string, custom map[string]HelperFunc) *tplHelper {
+	if locale == "" {
+		locale = cfg.Locale
+	}
+	l := lookupLocale(locale)
+	tz := time.Local
+	if cfg.Timezone != "" {
+		var err error
+		if tz, err = time.LoadLocation(cfg.Timezone); err != nil {
+			log.Println("loading the timezone of the helpers:", err.Error())
+			tz = time.Local
+		}
+	}
+	formats := cfg.DateFormats
+	if len(formats) == 0 {
+		formats = DefaultDateFormats
+	}
+	dates := map[string]HelperFunc{}
+	for name, layout := range formats {
+		dates[name] = textHelper(dateFormatter(layout, tz, l))
+	}
+	currencies := map[string]HelperFunc{}
+	for code := range currencySymbols {
+		currencies[code] = textHelper(currencyFormatter(code, l))
+	}
+	for _, code := range cfg.Currencies {
+		currencies[code] = textHelper(currencyFormatter(code, l))
+	}
+	truncateLength := cfg.TruncateLength
+	if truncateLength <= 0 {
+		truncateLength = DefaultTruncateLength
+	}
+	var base *url.URL
+	if cfg.BaseURL != "" {
+		var err error
+		if base, err = url.Parse(cfg.BaseURL); err != nil {
+			log.Println("parsing the base url of the helpers:", err.Error())
+		}
+	}
+
+	h := tplHelper{
+		"Date":      dates,
+		"Currency":  currencies,
+		"Number":    textHelper(numberFormatter(l)),
+		"Pluralize": textHelper(pluralizer(l)),
+		"Truncate":  textHelper(truncater(truncateLength)),
+		"Slugify":   textHelper(slugify),
+		"URL":       urlHelper(base),
+	}
+	for name, f := range custom {
+		h[name] = f
+	}
+	return &h
+}
+
+// textHelper creates a HelperFunc applying the received function to the rendered text of the
+// section, unescaped, and escaping its result
+func textHelper(f func(string) string) HelperFunc {
+	return func(text string, render RenderFunc) (string, error) {
+		s, err := render(text)
+		if err != nil {
+			return "", err
+		}
+		return html.EscapeString(f(strings.TrimSpace(html.UnescapeString(s)))), nil
+	}
+}
+
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// dateFormatter returns a function formatting the RFC 3339 dates, the dates without timezone and
+// the unix timestamps with the received layout, translating the names of the months and days
+func dateFormatter(layout string, tz *time.Location, l locale) func(string) string {
+	return func(s string) string {
+		var t time.Time
+		var err error
+		for _, dl := range dateLayouts {
+			if t, err = time.ParseInLocation(dl, s, tz); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			ts, perr := strconv.ParseFloat(s, 64)
+			if perr != nil {
+				return s
+			}
+			sec, dec := math.Modf(ts)
+			t = time.Unix(int64(sec), int64(dec*1e9))
+		}
+		return l.formatDate(t.In(tz), layout)
+	}
+}
+
+func numberFormatter(l locale) func(string) string {
+	return func(s string) string {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return s
+		}
+		n := l.formatNumber(f, 2)
+		if strings.Contains(n, l.decimal) {
+			n = strings.TrimRight(strings.TrimRight(n, "0"), l.decimal)
+		}
+		return n
+	}
+}
+
+func currencyFormatter(code string, l locale) func(string) string {
+	symbol, ok := currencySymbols[code]
+	if !ok {
+		symbol = code
+	}
+	decimals := 2
+	if code == "JPY" || code == "KRW" {
+		decimals = 0
+	}
+	return func(s string) string {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return s
+		}
+		n := l.formatNumber(math.Abs(f), decimals)
+		sign := ""
+		if f < 0 {
+			sign = "-"
+		}
+		if l.currencyAfter {
+			return sign + n + "\u00a0" + symbol
+		}
+		return sign + symbol + n
+	}
+}
+
+var currencySymbols = map[string]string{
+	"EUR": "€",
+	"USD": "$",
+	"GBP": "£",
+	"JPY": "¥",
+	"KRW": "₩",
+	"INR": "₹",
+	"BRL": "R$",
+	"MXN": "MX$",
+	"CHF": "CHF",
+}
+
+// pluralizer returns a function transforming texts like "3 item|items" into "3 items". A third
+// form, used alone, can be added for the zero: "0 no items|item|items"
+func pluralizer(l locale) func(string) string {
+	return func(s string) string {
+		parts := strings.SplitN(s, " ", 2)
+		if len(parts) != 2 {
+			return s
+		}
+		n, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return s
+		}
+		forms := strings.Split(strings.TrimSpace(parts[1]), "|")
+		switch len(forms) {
+		case 2:
+		case 3:
+			if n == 0 {
+				return forms[0]
+			}
+			forms = forms[1:]
+		default:
+			return s
+		}
+		if l.isOne(n) {
+			return parts[0] + " " + forms[0]
+		}
+		return parts[0] + " " + forms[1]
+	}
+}
+
+// truncater returns a function cutting the texts longer than the received number of characters
+// at the last word boundary, adding an ellipsis
+func truncater(length int) func(string) string {
+	return func(s string) string {
+		if utf8.RuneCountInString(s) <= length {
+			return s
+		}
+		runes := []rune(s)
+		cut := string(runes[:length])
+		if unicode.IsLetter(runes[length]) || unicode.IsDigit(runes[length]) {
+			if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > 0 {
+				cut = cut[:i]
+			}
+		}
+		return strings.TrimRightFunc(cut, func(r rune) bool {
+			return unicode.IsSpace(r) || unicode.IsPunct(r)
+		}) + "…"
+	}
+}
+
+var slugReplacer = strings.NewReplacer(
+	"á", "a", "à", "a", "ä", "a", "â", "a", "ã", "a", "å", "a",
+	"é", "e", "è", "e", "ë", "e", "ê", "e",
+	"í", "i", "ì", "i", "ï", "i", "î", "i",
+	"ó", "o", "ò", "o", "ö", "o", "ô", "o", "õ", "o", "ø", "o",
+	"ú", "u", "ù", "u", "ü", "u", "û", "u",
+	"ñ", "n", "ç", "c", "ß", "ss", "æ", "ae", "œ", "oe",
+)
+
+// slugify transforms the texts into lowercase ascii words separated by hyphens
+func slugify(s string) string {
+	s = slugReplacer.Replace(strings.ToLower(s))
+	b := make([]byte, 0, len(s))
+	hyphen := false
+	for _, r := range s {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if hyphen && len(b) > 0 {
+				b = append(b, '-')
+			}
+			b = append(b, byte(r))
+			hyphen = false
+			continue
+		}
+		hyphen = true
+	}
+	return string(b)
+}
+
+// urlHelper returns a HelperFunc building URLs from templates like "/search/{{q}}?page={{p}}".
+// Every path segment, query string key and value and the fragment are rendered and escaped on
+// their own and the relative URLs are resolved against the base URL, if any
+func urlHelper(base *url.URL) HelperFunc {
+	return func(text string, render RenderFunc) (string, error) {
+		var err error
+		r := func(s string) string {
+			if err != nil {
+				return ""
+			}
+			var v string
+			v, err = render(s)
+			return html.UnescapeString(v)
+		}
+
+		parts := splitOutsideTags(strings.TrimSpace(text), "#", 2)
+		fragment := ""
+		if len(parts) == 2 {
+			fragment = (&url.URL{Fragment: r(parts[1])}).EscapedFragment()
+		}
+		parts = splitOutsideTags(parts[0], "?", 2)
+		segments := splitOutsideTags(parts[0], "/", -1)
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(r(segment))
+		}
+		result := strings.Join(segments, "/")
+		if len(parts) == 2 {
+			pairs := splitOutsideTags(parts[1], "&", -1)
+			query := make([]string, 0, len(pairs))
+			for _, pair := range pairs {
+				kv := splitOutsideTags(pair, "=", 2)
+				if kv[0] == "" {
+					continue
+				}
+				q := url.QueryEscape(r(kv[0]))
+				if len(kv) == 2 {
+					q += "=" + url.QueryEscape(r(kv[1]))
+				}
+				query = append(query, q)
+			}
+			result += "?" + strings.Join(query, "&")
+		}
+		if fragment != "" {
+			result += "#" + fragment
+		}
+		if err != nil {
+			return "", err
+		}
+		if base != nil {
+			if u, err := url.Parse(result); err == nil {
+				result = base.ResolveReference(u).String()
+			}
+		}
+		return html.EscapeString(result), nil
+	}
+}
+
+// splitOutsideTags splits the template text around the separators not enclosed in a mustache tag
+func splitOutsideTags(s, sep string, n int) []string {
+	result := []string{}
+	depth, last := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"):
+			depth++
+			i++
+		case strings.HasPrefix(s[i:], "}}") && depth > 0:
+			depth--
+			i++
+		case depth == 0 && strings.HasPrefix(s[i:], sep) && (n < 0 || len(result) < n-1):
+			result = append(result, s[last:i])
+			last = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+	return append(result, s[last:])
+}
+
+// locale contains the conventions of a language for displaying numbers and dates
+type locale struct {
+	decimal       string
+	thousands     string
+	currencyAfter bool
+	// oneUpTo is the greatest number using the singular form, if greater than 1 (1.99 in french)
+	oneUpTo     float64
+	months      []string
+	days        []string
+	shortMonths int
+	shortDays   int
+}
+
+func (l locale) isOne(n float64) bool {
+	n = math.Abs(n)
+	return n == 1 || l.oneUpTo > 1 && n <= l.oneUpTo
+}
+
+func (l locale) formatNumber(f float64, decimals int) string {
+	s := strconv.FormatFloat(math.Abs(f), 'f', decimals, 64)
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+	b := &strings.Builder{}
+	if f < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, d := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteString(l.thousands)
+		}
+		b.WriteRune(d)
+	}
+	if fraction != "" {
+		b.WriteString(l.decimal)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
+
+// formatDate formats the time with the received layout, replacing the english names of the
+// months and days by the ones of the locale
+func (l locale) formatDate(t time.Time, layout string) string {
+	b := &strings.Builder{}
+	for layout != "" {
+		i, token := nextNameToken(layout)
+		if i < 0 {
+			b.WriteString(t.Format(layout))
+			break
+		}
+		if i > 0 {
+			b.WriteString(t.Format(layout[:i]))
+		}
+		b.WriteString(l.name(t, token))
+		layout = layout[i+len(token):]
+	}
+	return b.String()
+}
+
+func (l locale) name(t time.Time, token string) string {
+	switch token {
+	case "January":
+		return l.months[t.Month()-1]
+	case "Jan":
+		return shorten(l.months[t.Month()-1], l.shortMonths)
+	case "Monday":
+		return l.days[t.Weekday()]
+	}
+	return shorten(l.days[t.Weekday()], l.shortDays)
+}
+
+func shorten(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// nextNameToken returns the position of the first month or day name in the layout
+func nextNameToken(layout string) (int, string) {
+	for i := 0; i < len(layout); i++ {
+		for _, token := range []string{"January", "Jan", "Monday", "Mon"} {
+			if strings.HasPrefix(layout[i:], token) {
+				return i, token
+			}
+		}
+	}
+	return -1, ""
+}
+
+var locales = map[string]locale{
+	"en": {
+		decimal:     ".",
+		thousands:   ",",
+		months:      []string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		days:        []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+		shortMonths: 3,
+		shortDays:   3,
+	},
+	"es": {
+		decimal:       ",",
+		thousands:     ".",
+		currencyAfter: true,
+		months:        []string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		days:          []string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		shortMonths:   3,
+		shortDays:     3,
+	},
+	"fr": {
+		decimal:       ",",
+		thousands:     "\u202f",
+		currencyAfter: true,
+		oneUpTo:       1.99,
+		months:        []string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		days:          []string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		shortMonths:   4,
+		shortDays:     3,
+	},
+	"de": {
+		decimal:       ",",
+		thousands:     ".",
+		currencyAfter: true,
+		months:        []string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		days:          []string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		shortMonths:   3,
+		shortDays:     2,
+	},
+	"it": {
+		decimal:       ",",
+		thousands:     ".",
+		currencyAfter: true,
+		months:        []string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		days:          []string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		shortMonths:   3,
+		shortDays:     3,
+	},
+	"pt": {
+		decimal:     ",",
+		thousands:   ".",
+		months:      []string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+		days:        []string{"domingo", "segunda-feira", "terça-feira", "quarta-feira", "quinta-feira", "sexta-feira", "sábado"},
+		shortMonths: 3,
+		shortDays:   3,
+	},
+}
+
+// lookupLocale returns the locale for the received language tag (ex: "es-ES"), falling back to
+// the english one
+func lookupLocale(tag string) locale {
+	lang := strings.ToLower(strings.SplitN(strings.Replace(tag, "_", "-", -1), "-", 2)[0])
+	if l, ok := locales[lang]; ok {
+		return l
+	}
+	if lang != "" {
+		log.Println("unknown helper locale", tag, ": using the english one")
+	}
+	return locales["en"]
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTplHelper(t *testing.T) {
+	ctx := map[string]interface{}{
+		"date":  "2018-03-05T18:30:00Z",
+		"day":   "2018-03-04",
+		"ts":    1520274600,
+		"price": -1234.5,
+		"big":   1234567.891,
+		"n":     1,
+		"items": 3,
+		"none":  0,
+		"title": "Crème brûlée & <Friends>: the recipe!",
+		"text":  "The quick brown fox jumps over the lazy dog",
+		"q":     "a b&c",
+	}
+	for i, tc := range []struct {
+		cfg      HelperConfig
+		locale   string
+		tmpl     string
+		expected string
+	}{
+		{HelperConfig{Timezone: "UTC"}, "", `{{#Helper.Date.short}}{{date}}{{/Helper.Date.short}}`, "5 Mar 2018"},
+		{HelperConfig{Timezone: "UTC"}, "", `{{#Helper.Date.long}}{{day}}{{/Helper.Date.long}}`, "Sunday, 4 March 2018"},
+		{HelperConfig{Timezone: "UTC"}, "", `{{#Helper.Date.datetime}}{{ts}}{{/Helper.Date.datetime}}`, "5 Mar 2018 18:30"},
+		{HelperConfig{Timezone: "UTC"}, "es", `{{#Helper.Date.long}}{{date}}{{/Helper.Date.long}}`, "lunes, 5 marzo 2018"},
+		{HelperConfig{Timezone: "UTC", DateFormats: map[string]string{"custom": "Mon 02/01"}}, "de-DE", `{{#Helper.Date.custom}}{{date}}{{/Helper.Date.custom}}`, "Mo 05/03"},
+		{HelperConfig{Timezone: "Europe/Madrid"}, "", `{{#Helper.Date.time}}{{date}}{{/Helper.Date.time}}`, "19:30"},
+		{HelperConfig{}, "", `{{#Helper.Date.short}}yesterday{{/Helper.Date.short}}`, "yesterday"},
+		{HelperConfig{}, "", `{{#Helper.Number}}{{big}}{{/Helper.Number}}`, "1,234,567.89"},
+		{HelperConfig{}, "fr", `{{#Helper.Number}}{{price}}{{/Helper.Number}}`, "-1\u202f234,5"},
+		{HelperConfig{}, "", `{{#Helper.Currency.USD}}{{price}}{{/Helper.Currency.USD}}`, "-$1,234.50"},
+		{HelperConfig{}, "es", `{{#Helper.Currency.EUR}}{{big}}{{/Helper.Currency.EUR}}`, "1.234.567,89\u00a0€"},
+		{HelperConfig{}, "", `{{#Helper.Currency.JPY}}{{big}}{{/Helper.Currency.JPY}}`, "¥1,234,568"},
+		{HelperConfig{Currencies: []string{"SEK"}}, "", `{{#Helper.Currency.SEK}}{{n}}{{/Helper.Currency.SEK}}`, "SEK1.00"},
+		{HelperConfig{}, "", `{{#Helper.Pluralize}}{{n}} item|items{{/Helper.Pluralize}}`, "1 item"},
+		{HelperConfig{}, "", `{{#Helper.Pluralize}}{{items}} item|items{{/Helper.Pluralize}}`, "3 items"},
+		{HelperConfig{}, "", `{{#Helper.Pluralize}}{{none}} no items|item|items{{/Helper.Pluralize}}`, "no items"},
+		{HelperConfig{}, "", `{{#Helper.Pluralize}}{{none}} item|items{{/Helper.Pluralize}}`, "0 items"},
+		{HelperConfig{}, "fr", `{{#Helper.Pluralize}}{{none}} article|articles{{/Helper.Pluralize}}`, "0 article"},
+		{HelperConfig{TruncateLength: 18}, "", `{{#Helper.Truncate}}{{text}}{{/Helper.Truncate}}`, "The quick brown…"},
+		{HelperConfig{TruncateLength: 19}, "", `{{#Helper.Truncate}}{{text}}{{/Helper.Truncate}}`, "The quick brown fox…"},
+		{HelperConfig{}, "", `{{#Helper.Truncate}}{{title}}{{/Helper.Truncate}}`, "Crème brûlée &amp; &lt;Friends&gt;: the recipe!"},
+		{HelperConfig{}, "", `{{#Helper.Slugify}}{{title}}{{/Helper.Slugify}}`, "creme-brulee-friends-the-recipe"},
+		{HelperConfig{}, "", `<a href="{{#Helper.URL}}/search/{{q}}?q={{q}}{{/Helper.URL}}">`, `<a href="/search/a%20b&amp;c?q=a+b%26c">`},
+		{HelperConfig{}, "", `{{#Helper.URL}}/a/{{{q}}}?{{&q}}=1&x&=2#{{q}}{{/Helper.URL}}`, "/a/a%20b&amp;c?a+b%26c=1&amp;x#a%20b&amp;c"},
+		{HelperConfig{BaseURL: "https://example.com/shop/"}, "", `{{#Helper.URL}}products?page=2{{/Helper.URL}}`, "https://example.com/shop/products?page=2"},
+		{HelperConfig{BaseURL: "https://example.com/shop/"}, "", `{{#Helper.URL}}https://cdn.example.com/{{q}}.png{{/Helper.URL}}`, "https://cdn.example.com/a%20b&amp;c.png"},
+	} {
+		tmpl, err := NewMustacheRenderer(bytes.NewBufferString(tc.tmpl))
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		ctx["Helper"] = newTplHelper(tc.cfg, tc.locale, nil)
+		w := &bytes.Buffer{}
+		if err := tmpl.Render(w, ctx); err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if w.String() != tc.expected {
+			t.Errorf("#%d: unexpected render result: %s", i, w.String())
+		}
+	}
+}
+
+func TestTplHelper_custom(t *testing.T) {
+	h := newTplHelper(HelperConfig{}, "", map[string]HelperFunc{
+		"Upper": func(text string, render RenderFunc) (string, error) {
+			s, err := render(text)
+			return strings.ToUpper(s), err
+		},
+	})
+	tmpl, err := NewMustacheRenderer(bytes.NewBufferString(`{{#Helper.Upper}}{{Data.name}}{{/Helper.Upper}} {{#Helper.Now}}now{{/Helper.Now}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	w := &bytes.Buffer{}
+	if err := tmpl.Render(w, ResponseContext{Helper: h, Data: map[string]interface{}{"name": "<b>"}}); err != nil {
+		t.Error(err)
+		return
+	}
+	if w.String() != "&LT;B&GT; now" {
+		t.Errorf("unexpected render result: %s", w.String())
+	}
+}
@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"text/template/parse"
+)
+
+// NewHTMLTemplateRenderer returns an HTMLTemplateRenderer and an error if something went wrong
+func NewHTMLTemplateRenderer(r io.Reader) (*HTMLTemplateRenderer, error) {
+	return NewHTMLTemplateRendererWithPartials(r, customPartialProvider)
+}
+
+// NewHTMLTemplateRendererWithPartials returns an HTMLTemplateRenderer and an error if something
+// went wrong. The templates called by the template and not defined by it, ex: {{template "header" .}},
+// are resolved as partials with the received provider
+func NewHTMLTemplateRendererWithPartials(r io.Reader, partials PartialProvider) (*HTMLTemplateRenderer, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New("template").Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	names, err := addHTMLPartials(tmpl, partials)
+	if err != nil {
+		return nil, err
+	}
+	return &HTMLTemplateRenderer{tmpl, string(data), partials, names, []string{}}, nil
+}
+
+// HTMLTemplateRenderer is a renderer using the html/template package, so the values are escaped
+// depending on their context
+type HTMLTemplateRenderer struct {
+	tmpl     *template.Template
+	src      string
+	provider PartialProvider
+	partials []string
+	// layouts are the sources of the layouts the template extends, starting with the outermost one
+	layouts []string
+}
+
+// Render implements the renderer interface
+func (h HTMLTemplateRenderer) Render(w io.Writer, v interface{}) error {
+	return h.tmpl.Execute(w, v)
+}
+
+// Partials implements the PartialDependent interface
+func (h HTMLTemplateRenderer) Partials() []string {
+	return h.partials
+}
+
+// WithLayout implements the LayoutComposer interface. The template is added to the layout as the
+// "content" template, so the layout can render it with {{template "content" .}}. The templates
+// defined by the template override the ones with the same name in the layout. The result can be
+// used as the layout of other templates, so layouts can extend other layouts
+func (h HTMLTemplateRenderer) WithLayout(layout Renderer) (Renderer, error) {
+	l, ok := layout.(*HTMLTemplateRenderer)
+	if !ok {
+		return nil, fmt.Errorf("the layout of an html template must be an html template")
+	}
+	layouts := append(append([]string{}, l.layouts...), l.src)
+	tmpl := template.New("layout")
+	for i, src := range layouts {
+		t := tmpl
+		if i > 0 {
+			t = tmpl.New(htmlLayoutName(i))
+		}
+		if _, err := t.Parse(src); err != nil {
+			return nil, err
+		}
+		if i < len(layouts)-1 {
+			// the content of this layout is the next one
+			for _, t := range tmpl.Templates() {
+				if t.Tree != nil {
+					renameTemplateCalls(t.Tree.Root, "content", htmlLayoutName(i+1))
+				}
+			}
+		}
+	}
+	if _, err := tmpl.New("content").Parse(h.src); err != nil {
+		return nil, err
+	}
+	names, err := addHTMLPartials(tmpl, h.provider)
+	if err != nil {
+		return nil, err
+	}
+	return &HTMLTemplateRenderer{tmpl, h.src, h.provider, names, layouts}, nil
+}
+
+func htmlLayoutName(level int) string {
+	return fmt.Sprintf("api2html/layout/%d", level)
+}
+
+// addHTMLPartials parses the partials called and not defined by the templates of the set into it,
+// returning the names of all of them
+func addHTMLPartials(tmpl *template.Template, partials PartialProvider) ([]string, error) {
+	names := map[string]bool{}
+	visited := map[string]bool{}
+	pending := tmpl.Templates()
+	for len(pending) > 0 {
+		t := pending[0]
+		pending = pending[1:]
+		if visited[t.Name()] || t.Tree == nil {
+			continue
+		}
+		visited[t.Name()] = true
+		calls := map[string]bool{}
+		templateCalls(t.Tree.Root, calls)
+		for name := range calls {
+			if names[name] || tmpl.Lookup(name) != nil {
+				continue
+			}
+			names[name] = true
+			src, err := partials.Get(name)
+			if err != nil {
+				return nil, err
+			}
+			if src == "" {
+				continue
+			}
+			if _, err := tmpl.New(name).Parse(src); err != nil {
+				return nil, fmt.Errorf("parsing the partial %s: %s", name, err.Error())
+			}
+			pending = append(pending, tmpl.Templates()...)
+		}
+	}
+	return partialNames(names), nil
+}
+
+// renameTemplateCalls makes the calls to the template with the old name from the node call the
+// template with the new name
+func renameTemplateCalls(node parse.Node, old, new string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			renameTemplateCalls(child, old, new)
+		}
+	case *parse.TemplateNode:
+		if n.Name == old {
+			n.Name = new
+		}
+	case *parse.IfNode:
+		renameTemplateCalls(n.List, old, new)
+		renameTemplateCalls(n.ElseList, old, new)
+	case *parse.RangeNode:
+		renameTemplateCalls(n.List, old, new)
+		renameTemplateCalls(n.ElseList, old, new)
+	case *parse.WithNode:
+		renameTemplateCalls(n.List, old, new)
+		renameTemplateCalls(n.ElseList, old, new)
+	}
+}
+
+// templateCalls collects the names of the templates called from the node
+func templateCalls(node parse.Node, names map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			templateCalls(child, names)
+		}
+	case *parse.TemplateNode:
+		names[n.Name] = true
+	case *parse.IfNode:
+		templateCalls(n.List, names)
+		templateCalls(n.ElseList, names)
+	case *parse.RangeNode:
+		templateCalls(n.List, names)
+		templateCalls(n.ElseList, names)
+	case *parse.WithNode:
+		templateCalls(n.List, names)
+		templateCalls(n.ElseList, names)
+	}
+}
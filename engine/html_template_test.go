@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewHTMLTemplateRenderer(t *testing.T) {
+	tmpl, err := NewHTMLTemplateRenderer(bytes.NewBufferString(`{{range $i, $item := .Array}}{{if $i}}, {{end}}<a href="/items?q={{$item.name}}">{{$i}}: {{$item.name}}</a>{{end}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	w := &bytes.Buffer{}
+	ctx := ResponseContext{Array: []map[string]interface{}{{"name": "a&b"}, {"name": "<c>"}}}
+	if err := tmpl.Render(w, ctx); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `<a href="/items?q=a%26b">0: a&amp;b</a>, <a href="/items?q=%3cc%3e">1: &lt;c&gt;</a>`
+	if w.String() != expected {
+		t.Errorf("unexpected render result: %s", w.String())
+	}
+
+	if _, err := NewHTMLTemplateRenderer(bytes.NewBufferString(`{{if .a}}`)); err == nil {
+		t.Error("expecting error")
+	}
+}
+
+func TestHTMLTemplateRenderer_WithLayout(t *testing.T) {
+	layout, err := NewHTMLTemplateRenderer(bytes.NewBufferString(`<title>{{block "title" .}}default{{end}}</title>-{{template "content" .}}-`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tmpl, err := NewHTMLTemplateRenderer(bytes.NewBufferString(`{{define "title"}}{{.a}} page{{end}}{{.a}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r, err := tmpl.WithLayout(layout)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	w := &bytes.Buffer{}
+	if err := r.Render(w, map[string]interface{}{"a": 42}); err != nil {
+		t.Error(err)
+		return
+	}
+	if w.String() != "<title>42 page</title>-42-" {
+		t.Errorf("unexpected render result: %s", w.String())
+	}
+
+	w.Reset()
+	if err := tmpl.Render(w, map[string]interface{}{"a": 42}); err != nil || w.String() != "42" {
+		t.Errorf("unexpected render result: %s, %v", w.String(), err)
+	}
+
+	mustacheLayout, _ := NewMustacheRenderer(bytes.NewBufferString(`-{{{ content }}}-`))
+	if _, err := tmpl.WithLayout(mustacheLayout); err == nil {
+		t.Error("expecting error")
+	}
+}
+
+func TestHTMLTemplateRenderer_WithLayout_nested(t *testing.T) {
+	base, err := NewHTMLTemplateRenderer(bytes.NewBufferString(`<head>{{block "head" .}}<title>base</title>{{end}}</head><body>{{template "content" .}}{{block "scripts" .}}{{end}}</body>`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	section, err := NewHTMLTemplateRenderer(bytes.NewBufferString(`{{define "head"}}<title>section</title>{{end}}<aside>{{block "sidebar" .}}menu{{end}}</aside><main>{{template "content" .}}</main>`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tmpl, err := NewHTMLTemplateRenderer(bytes.NewBufferString(`{{define "sidebar"}}{{.a}} links{{end}}{{define "scripts"}}<script src="/a.js"></script>{{end}}{{.a}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	layout, err := section.WithLayout(base)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r, err := tmpl.WithLayout(layout)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	w := &bytes.Buffer{}
+	if err := r.Render(w, map[string]interface{}{"a": 42}); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `<head><title>section</title></head><body><aside>42 links</aside><main>42</main><script src="/a.js"></script></body>`
+	if w.String() != expected {
+		t.Errorf("unexpected render result: %s", w.String())
+	}
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultDataFolder is the folder containing the files of the file:// backends if the config does
+// not define another one
+const DefaultDataFolder = "./data"
+
+const fileScheme = "file://"
+
+func isFilePattern(URLPattern string) bool {
+	return strings.HasPrefix(URLPattern, fileScheme)
+}
+
+// NewFileBackend creates a Backend reading the files of the data folder matching the received
+// pattern (ex: file://stores/:city.json). The params are inserted into the path as they are, but
+// the ones not listed as raw can not contain path separators or "..", and the final path must be
+// inside the data folder. Missing files are returned as responses with a 404 status code
+func NewFileBackend(dataFolder, URLPattern string, rawParams []string) Backend {
+	if dataFolder == "" {
+		dataFolder = DefaultDataFolder
+	}
+	root, err := filepath.Abs(dataFolder)
+	if err != nil {
+		return errorBackend(err)
+	}
+	pattern := []byte(strings.TrimPrefix(URLPattern, fileScheme))
+	raw := make(map[string]bool, len(rawParams))
+	for _, k := range rawParams {
+		raw[k] = true
+	}
+
+	return func(params map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+		for _, k := range usedParams(pattern, params) {
+			if !raw[k] && !isFileName(params[k]) {
+				return nil, ErrPathNotAllowed
+			}
+		}
+		all := make(map[string]bool, len(params))
+		for k := range params {
+			all[k] = true
+		}
+		rel := filepath.FromSlash(string(replaceParamsWithRaw(pattern, params, all)))
+		path := filepath.Join(root, rel)
+		if !strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return nil, ErrPathNotAllowed
+		}
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			return localResponse(http.StatusNotFound, "", ioutil.NopCloser(&bytes.Buffer{})), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return localResponse(http.StatusOK, mime.TypeByExtension(filepath.Ext(path)), f), nil
+	}
+}
+
+// isFileName returns false if the value could point to another folder
+func isFileName(value string) bool {
+	return !strings.ContainsAny(value, `/\`) && !strings.Contains(value, "..")
+}
+
+// NewInlineBackend creates a Backend responding with the JSON encoding of the received data
+func NewInlineBackend(data interface{}) Backend {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return errorBackend(err)
+	}
+	return func(_ map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+		return localResponse(http.StatusOK, "application/json", ioutil.NopCloser(bytes.NewReader(b))), nil
+	}
+}
+
+func localResponse(status int, contentType string, body io.ReadCloser) *http.Response {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return &http.Response{StatusCode: status, Header: header, Body: body}
+}
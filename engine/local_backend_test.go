@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNewFileBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api2html-data")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	dataFolder := filepath.Join(dir, "data")
+	if err := os.MkdirAll(filepath.Join(dataFolder, "stores", "es"), 0755); err != nil {
+		t.Error(err)
+		return
+	}
+	files := map[string]string{
+		filepath.Join(dataFolder, "stores", "madrid.json"):       `{"city":"Madrid"}`,
+		filepath.Join(dataFolder, "stores", "São Paulo.json"):    `{"city":"São Paulo"}`,
+		filepath.Join(dataFolder, "stores", "es", "bilbao.json"): `{"city":"Bilbao"}`,
+		filepath.Join(dir, "secret.json"):                        `{"secret":true}`,
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	for i, tc := range []struct {
+		pattern string
+		params  map[string]string
+		raw     []string
+		status  int
+		body    string
+		err     error
+	}{
+		{"file://stores/:city.json", map[string]string{"city": "madrid"}, nil, 200, `{"city":"Madrid"}`, nil},
+		{"file://stores/:city.json", map[string]string{"city": "unknown"}, nil, 404, "", nil},
+		{"file://stores/:city.json", map[string]string{"city": "São Paulo"}, nil, 200, `{"city":"São Paulo"}`, nil},
+		{"file://stores/:city.json", map[string]string{"city": "es/bilbao"}, nil, 0, "", ErrPathNotAllowed},
+		{"file://stores/:city.json", map[string]string{"city": `es\bilbao`}, nil, 0, "", ErrPathNotAllowed},
+		{"file://stores/:city.json", map[string]string{"city": "madrid", "unused": "../a"}, nil, 200, `{"city":"Madrid"}`, nil},
+		{"file://stores/:city.json", map[string]string{"city": "es/bilbao"}, []string{"city"}, 200, `{"city":"Bilbao"}`, nil},
+		{"file://stores/:idx.json", map[string]string{"idx": "madrid", "id": "../a"}, nil, 200, `{"city":"Madrid"}`, nil},
+		{"file://stores/:id.json", map[string]string{"id": "madrid", "idx": "../a"}, nil, 200, `{"city":"Madrid"}`, nil},
+		{"file://stores/:idx.json", map[string]string{"idx": "../madrid", "id": "madrid"}, nil, 0, "", ErrPathNotAllowed},
+		{"file://:file.json", map[string]string{"file": "../secret"}, nil, 0, "", ErrPathNotAllowed},
+		{"file://:file.json", map[string]string{"file": "../secret"}, []string{"file"}, 0, "", ErrPathNotAllowed},
+		{"file://:file", map[string]string{"file": ".."}, []string{"file"}, 0, "", ErrPathNotAllowed},
+	} {
+		resp, err := NewFileBackend(dataFolder, tc.pattern, tc.raw)(tc.params, nil, nil)
+		if err != tc.err {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("#%d: unexpected status code: %d", i, resp.StatusCode)
+		}
+		if string(body) != tc.body {
+			t.Errorf("#%d: unexpected body: %s", i, string(body))
+		}
+		if tc.status == 200 && resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("#%d: unexpected content type: %s", i, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestNewHandlerConfig_localData(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir, err := ioutil.TempDir("", "api2html-data")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "madrid.json"), []byte(`{"city":"Madrid"}`), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, tc := range []struct {
+		page  Page
+		check func(ResponseContext) bool
+		err   bool
+	}{
+		{
+			page: Page{Data: map[string]interface{}{"title": "Legal"}},
+			check: func(r ResponseContext) bool {
+				return r.Data["title"] == "Legal"
+			},
+		},
+		{
+			page: Page{IsArray: true, Data: []interface{}{map[string]interface{}{"name": "Home"}}},
+			check: func(r ResponseContext) bool {
+				return len(r.Array) == 1 && r.Array[0]["name"] == "Home"
+			},
+		},
+		{
+			page: Page{BackendURLPattern: "file://:city.json", DataFolder: dir},
+			check: func(r ResponseContext) bool {
+				return r.Data["city"] == "Madrid"
+			},
+		},
+		{
+			page: Page{Backends: []BackendConfig{{Name: "store", URLPattern: "file://:city.json"}}, DataFolder: dir},
+			check: func(r ResponseContext) bool {
+				store, ok := r.Backends["store"].(map[string]interface{})
+				return ok && store["city"] == "Madrid"
+			},
+		},
+		{
+			page: Page{BackendURLPattern: "file://unknown.json", DataFolder: dir},
+			err:  true,
+		},
+	} {
+		cfg := NewHandlerConfig(tc.page)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/stores/madrid", nil)
+		c.Params = gin.Params{{Key: "city", Value: "madrid"}}
+		result, err := cfg.ResponseGenerator(c)
+		if tc.err {
+			if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusNotFound {
+				t.Errorf("#%d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !tc.check(result) {
+			t.Errorf("#%d: unexpected result: %v", i, result)
+		}
+	}
+}
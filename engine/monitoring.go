@@ -0,0 +1,17 @@
+package engine
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MonitoringHandler is a gin handler exposing the state of the monitored components as JSON
+func MonitoringHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"circuit_breakers": CircuitBreakers(),
+		"caches":           Caches(),
+		"balancers":        Balancers(),
+		"schemas":          SchemaValidators(),
+	})
+}
@@ -1,10 +1,12 @@
 package engine
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"regexp"
 
 	"github.com/cbroglie/mustache"
 )
@@ -34,16 +36,40 @@ func NewMustacheRendererMap(cfg Config) (map[string]*MustacheRenderer, error) {
 
 // NewMustacheRenderer returns a MustacheRenderer and an error if something went wrong
 func NewMustacheRenderer(r io.Reader) (*MustacheRenderer, error) {
-	tmpl, err := newMustacheTemplate(r)
+	return NewMustacheRendererWithPartials(r, customPartialProvider)
+}
+
+// NewMustacheRendererWithPartials returns a MustacheRenderer resolving its partials with the
+// received provider and an error if something went wrong
+func NewMustacheRendererWithPartials(r io.Reader, partials PartialProvider) (*MustacheRenderer, error) {
+	data, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	return &MustacheRenderer{tmpl}, nil
+	return newMustacheRenderer(string(data), partials)
 }
 
-// MustacheRenderer is a simple mustache renderer with a single mustache template
+func newMustacheRenderer(src string, partials PartialProvider) (*MustacheRenderer, error) {
+	data, err := stripMustacheBlocks(src)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := mustache.ParseStringPartials(data, partials)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	mustachePartials(tmpl.Tags(), partials, names)
+	return &MustacheRenderer{tmpl, partialNames(names), src, partials}, nil
+}
+
+// MustacheRenderer is a simple mustache renderer with a single mustache template. Rendered alone,
+// the named blocks of the template ({{$name}}...{{/name}}) are rendered in place
 type MustacheRenderer struct {
-	tmpl *mustache.Template
+	tmpl     *mustache.Template
+	partials []string
+	src      string
+	provider PartialProvider
 }
 
 // Render implements the renderer interface
@@ -51,13 +77,43 @@ func (m MustacheRenderer) Render(w io.Writer, v interface{}) error {
 	return m.tmpl.FRender(w, v)
 }
 
+// WithLayout implements the LayoutComposer interface. The named blocks of the template replace the
+// blocks with the same name in the layout and the rest of the template, including the blocks not
+// defined by the layout, replaces the {{{content}}} tag of the layout. The result can be used as
+// the layout of other templates, so layouts can extend other layouts
+func (m MustacheRenderer) WithLayout(layout Renderer) (Renderer, error) {
+	l, ok := layout.(*MustacheRenderer)
+	if !ok {
+		return nil, fmt.Errorf("the layout of a mustache template must be a mustache template")
+	}
+	names, err := mustacheBlockNames(l.src)
+	if err != nil {
+		return nil, err
+	}
+	body, blocks, err := splitMustacheBlocks(m.src, names)
+	if err != nil {
+		return nil, err
+	}
+	src, err := fillMustacheBlocks(l.src, blocks)
+	if err != nil {
+		return nil, err
+	}
+	src = mustacheContentTag.ReplaceAllStringFunc(src, func(_ string) string { return body })
+	return newMustacheRenderer(src, m.provider)
+}
+
+// Partials implements the PartialDependent interface
+func (m MustacheRenderer) Partials() []string {
+	return m.partials
+}
+
 // NewLayoutMustacheRenderer returns a LayoutMustacheRenderer and an error if something went wrong
 func NewLayoutMustacheRenderer(t, l io.Reader) (*LayoutMustacheRenderer, error) {
-	tmpl, err := newMustacheTemplate(t)
+	tmpl, err := newMustacheTemplate(t, customPartialProvider)
 	if err != nil {
 		return nil, err
 	}
-	layout, err := newMustacheTemplate(l)
+	layout, err := newMustacheTemplate(l, customPartialProvider)
 	if err != nil {
 		return nil, err
 	}
@@ -75,12 +131,172 @@ func (m LayoutMustacheRenderer) Render(w io.Writer, v interface{}) error {
 	return m.tmpl.FRenderInLayout(w, m.layout, v)
 }
 
-func newMustacheTemplate(r io.Reader) (*mustache.Template, error) {
+func newMustacheTemplate(r io.Reader, partials PartialProvider) (*mustache.Template, error) {
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	return mustache.ParseStringPartials(string(data), customPartialProvider)
+	return mustache.ParseStringPartials(string(data), partials)
+}
+
+// mustachePartials collects the names of the partials included by the tags, following the
+// partials included by other partials
+func mustachePartials(tags []mustache.Tag, partials PartialProvider, names map[string]bool) {
+	for _, tag := range tags {
+		switch tag.Type() {
+		case mustache.Section, mustache.InvertedSection:
+			mustachePartials(tag.Tags(), partials, names)
+		case mustache.Partial:
+			if names[tag.Name()] {
+				continue
+			}
+			names[tag.Name()] = true
+			src, err := partials.Get(tag.Name())
+			if err != nil || src == "" {
+				continue
+			}
+			if tmpl, err := mustache.ParseStringPartials(src, partials); err == nil {
+				mustachePartials(tmpl.Tags(), partials, names)
+			}
+		}
+	}
+}
+
+var (
+	mustacheContentTag   = regexp.MustCompile(`\{\{\{\s*content\s*\}\}\}|\{\{&\s*content\s*\}\}`)
+	mustacheBlockOpenTag = regexp.MustCompile(`\{\{\$\s*([^\s}]+)\s*\}\}`)
+	mustacheSectionTag   = regexp.MustCompile(`\{\{([#^$/])\s*([^\s}]+)\s*\}\}`)
+)
+
+// mustacheBlock is the position of a named block ({{$name}}body{{/name}}) in a template
+type mustacheBlock struct {
+	name      string
+	start     int
+	bodyStart int
+	bodyEnd   int
+	end       int
+}
+
+// mustacheBlocks returns the top level named blocks of the template. The sections and blocks with
+// the same name nested in a block are balanced, so they do not close it
+func mustacheBlocks(src string) ([]mustacheBlock, error) {
+	blocks := []mustacheBlock{}
+	for offset := 0; ; {
+		loc := mustacheBlockOpenTag.FindStringSubmatchIndex(src[offset:])
+		if loc == nil {
+			return blocks, nil
+		}
+		name := src[offset+loc[2] : offset+loc[3]]
+		bodyStart := offset + loc[1]
+		end := mustacheBlockEnd(src[bodyStart:], name)
+		if end == nil {
+			return nil, fmt.Errorf("unclosed block: %s", name)
+		}
+		block := mustacheBlock{name, offset + loc[0], bodyStart, bodyStart + end[0], bodyStart + end[1]}
+		blocks = append(blocks, block)
+		offset = block.end
+	}
+}
+
+// mustacheBlockEnd returns the position of the tag closing the block with the received name
+func mustacheBlockEnd(body, name string) []int {
+	depth := 0
+	for _, loc := range mustacheSectionTag.FindAllStringSubmatchIndex(body, -1) {
+		if body[loc[4]:loc[5]] != name {
+			continue
+		}
+		if body[loc[2]:loc[3]] != "/" {
+			depth++
+			continue
+		}
+		if depth == 0 {
+			return loc[:2]
+		}
+		depth--
+	}
+	return nil
+}
+
+// mustacheBlockNames returns the names of all the named blocks of the template, nested ones included
+func mustacheBlockNames(src string) (map[string]bool, error) {
+	blocks, err := mustacheBlocks(src)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, b := range blocks {
+		names[b.name] = true
+		nested, err := mustacheBlockNames(src[b.bodyStart:b.bodyEnd])
+		if err != nil {
+			return nil, err
+		}
+		for name := range nested {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+// splitMustacheBlocks returns the template without the top level named blocks with the received
+// names and the bodies of them
+func splitMustacheBlocks(src string, names map[string]bool) (string, map[string]string, error) {
+	blocks, err := mustacheBlocks(src)
+	if err != nil {
+		return "", nil, err
+	}
+	bodies := map[string]string{}
+	body := ""
+	last := 0
+	for _, b := range blocks {
+		if !names[b.name] {
+			continue
+		}
+		body += src[last:b.start]
+		bodies[b.name] = src[b.bodyStart:b.bodyEnd]
+		last = b.end
+	}
+	return body + src[last:], bodies, nil
+}
+
+// fillMustacheBlocks replaces the bodies of the named blocks of the template, nested ones included,
+// with the received ones. The blocks are kept, so they can be filled again
+func fillMustacheBlocks(src string, bodies map[string]string) (string, error) {
+	blocks, err := mustacheBlocks(src)
+	if err != nil {
+		return "", err
+	}
+	result := ""
+	last := 0
+	for _, b := range blocks {
+		body, ok := bodies[b.name]
+		if !ok {
+			if body, err = fillMustacheBlocks(src[b.bodyStart:b.bodyEnd], bodies); err != nil {
+				return "", err
+			}
+		}
+		result += src[last:b.bodyStart] + body + src[b.bodyEnd:b.end]
+		last = b.end
+	}
+	return result + src[last:], nil
+}
+
+// stripMustacheBlocks removes the tags of the named blocks of the template, keeping their bodies
+func stripMustacheBlocks(src string) (string, error) {
+	blocks, err := mustacheBlocks(src)
+	if err != nil {
+		return "", err
+	}
+	result := ""
+	last := 0
+	for _, b := range blocks {
+		body, err := stripMustacheBlocks(src[b.bodyStart:b.bodyEnd])
+		if err != nil {
+			return "", err
+		}
+		result += src[last:b.start] + body
+		last = b.end
+	}
+	return result + src[last:], nil
 }
 
 type partialProvider struct {
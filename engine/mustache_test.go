@@ -69,6 +69,114 @@ func TestNewLayoutMustacheRenderer_ko(t *testing.T) {
 	}
 }
 
+func TestMustacheRenderer_WithLayout(t *testing.T) {
+	base, err := NewMustacheRenderer(bytes.NewBufferString(`<head>{{$head}}<title>base</title>{{/head}}</head><body>{{{content}}}{{$scripts}}{{/scripts}}</body>`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	section, err := NewMustacheRenderer(bytes.NewBufferString(`{{$head}}<title>section</title>{{/head}}<aside>{{$sidebar}}menu{{/sidebar}}</aside><main>{{{ content }}}</main>`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tmpl, err := NewMustacheRenderer(bytes.NewBufferString(`{{$sidebar}}{{a}} links{{/sidebar}}{{a}}{{$scripts}}<script src="/a.js"></script>{{/scripts}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, tc := range []struct {
+		renderer Renderer
+		expected string
+	}{
+		{base, `<head><title>base</title></head><body></body>`},
+		{tmpl, `42 links42<script src="/a.js"></script>`},
+	} {
+		w := &bytes.Buffer{}
+		if err := tc.renderer.Render(w, map[string]interface{}{"a": 42}); err != nil {
+			t.Error(err)
+			continue
+		}
+		if w.String() != tc.expected {
+			t.Errorf("unexpected render result: %s", w.String())
+		}
+	}
+
+	layout, err := section.WithLayout(base)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r, err := tmpl.WithLayout(layout)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	w := &bytes.Buffer{}
+	if err := r.Render(w, map[string]interface{}{"a": 42}); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `<head><title>section</title></head><body><aside>42 links</aside><main>42</main><script src="/a.js"></script></body>`
+	if w.String() != expected {
+		t.Errorf("unexpected render result: %s", w.String())
+	}
+
+	if _, err := tmpl.WithLayout(EmptyRenderer); err == nil {
+		t.Error("expecting error")
+	}
+}
+
+func TestMustacheRenderer_WithLayout_nestedSections(t *testing.T) {
+	layout, err := NewMustacheRenderer(bytes.NewBufferString(`<aside>{{$sidebar}}{{#sidebar}}<b>{{.}}</b>{{/sidebar}}{{/sidebar}}</aside>{{{content}}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tmpl, err := NewMustacheRenderer(bytes.NewBufferString(`{{$sidebar}}{{#sidebar}}<i>{{.}}</i>{{/sidebar}}{{/sidebar}}{{a}}`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r, err := tmpl.WithLayout(layout)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, tc := range []struct {
+		renderer Renderer
+		expected string
+	}{
+		{layout, "<aside><b>x</b><b>y</b></aside>"},
+		{r, "<aside><i>x</i><i>y</i></aside>42"},
+	} {
+		w := &bytes.Buffer{}
+		if err := tc.renderer.Render(w, map[string]interface{}{"a": 42, "sidebar": []string{"x", "y"}}); err != nil {
+			t.Error(err)
+			continue
+		}
+		if w.String() != tc.expected {
+			t.Errorf("unexpected render result: %s", w.String())
+		}
+	}
+}
+
+func TestMustacheRenderer_WithLayout_ko(t *testing.T) {
+	if _, err := NewMustacheRenderer(bytes.NewBufferString(`{{$head}}<title>`)); err == nil {
+		t.Error("expecting error")
+	}
+	if _, _, err := splitMustacheBlocks(`{{$head}}<title>`, map[string]bool{}); err == nil {
+		t.Error("expecting error")
+	}
+	if _, err := fillMustacheBlocks(`{{$head}}<title>`, map[string]string{}); err == nil {
+		t.Error("expecting error")
+	}
+	if _, err := mustacheBlockNames(`{{$head}}{{$title}}{{/head}}`); err == nil {
+		t.Error("expecting error")
+	}
+}
+
 func TestNewMustacheRendererMap_ok(t *testing.T) {
 	layoutPath := "a_layout.mustache"
 	templatePath := "template.mustache"
@@ -123,7 +231,7 @@ func TestNewMustacheRendererMap_koNoFile(t *testing.T) {
 func Test_newMustacheTemplate(t *testing.T) {
 	b := make([]byte, 1024)
 	rand.Read(b)
-	if _, err := newMustacheTemplate(iotest.TimeoutReader(bytes.NewBuffer(b))); err == nil {
+	if _, err := newMustacheTemplate(iotest.TimeoutReader(bytes.NewBuffer(b)), customPartialProvider); err == nil {
 		t.Error("expecting error!")
 	}
 }
@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryDelta is the time before the expiration of a token when it is considered expired,
+// so it is refreshed before the backends start rejecting it
+const tokenExpiryDelta = 10 * time.Second
+
+var tokenSources = &sync.Map{}
+
+// TokenSource returns the bearer tokens to attach to the backend requests
+type TokenSource interface {
+	// Token returns a valid token
+	Token() (string, error)
+	// Invalidate discards the received token, so the next call to Token gets a new one
+	Invalidate(token string)
+}
+
+// NewClientCredentials returns the TokenSource for the received config. The token sources are
+// shared by all the backends with the same oauth2 config (token endpoint, client, scopes, endpoint
+// params, auth style and timeout), so every token is requested once
+func NewClientCredentials(cfg OAuth2Config) *ClientCredentials {
+	cfg.ClientID = os.ExpandEnv(cfg.ClientID)
+	cfg.ClientSecret = os.ExpandEnv(cfg.ClientSecret)
+	// the map keys are sorted by the encoder, so the key does not depend on their order
+	key, _ := json.Marshal(cfg)
+	ts, _ := tokenSources.LoadOrStore(string(key), &ClientCredentials{
+		cfg:    cfg,
+		client: &http.Client{Timeout: parseDuration(cfg.Timeout, 10*time.Second)},
+		mutex:  &sync.Mutex{},
+	})
+	return ts.(*ClientCredentials)
+}
+
+// ClientCredentials is a TokenSource getting the tokens from an OAuth2 token endpoint with the
+// client credentials grant. The tokens are cached until they are about to expire
+type ClientCredentials struct {
+	cfg    OAuth2Config
+	client *http.Client
+	token  string
+	expiry time.Time
+	mutex  *sync.Mutex
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Token implements the TokenSource interface. If the token endpoint fails while the cached
+// token is still valid, the cached one is returned
+func (cc *ClientCredentials) Token() (string, error) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	now := time.Now()
+	if cc.token != "" && (cc.expiry.IsZero() || now.Add(tokenExpiryDelta).Before(cc.expiry)) {
+		return cc.token, nil
+	}
+
+	tr, err := cc.fetch()
+	if err != nil {
+		log.Println("fetching the oauth2 token:", err.Error())
+		if cc.token != "" && (cc.expiry.IsZero() || now.Before(cc.expiry)) {
+			return cc.token, nil
+		}
+		return "", err
+	}
+
+	cc.token = tr.AccessToken
+	cc.expiry = time.Time{}
+	if tr.ExpiresIn > 0 {
+		cc.expiry = now.Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return cc.token, nil
+}
+
+// Invalidate implements the TokenSource interface
+func (cc *ClientCredentials) Invalidate(token string) {
+	cc.mutex.Lock()
+	if cc.token == token {
+		cc.token = ""
+	}
+	cc.mutex.Unlock()
+}
+
+func (cc *ClientCredentials) fetch() (tokenResponse, error) {
+	tr := tokenResponse{}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(cc.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(cc.cfg.Scopes, " "))
+	}
+	for k, v := range cc.cfg.EndpointParams {
+		form.Set(k, v)
+	}
+	if cc.cfg.AuthStyle == "params" {
+		form.Set("client_id", cc.cfg.ClientID)
+		form.Set("client_secret", cc.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequest("POST", cc.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tr, &TokenError{TokenURL: cc.cfg.TokenURL, Err: err}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if cc.cfg.AuthStyle != "params" {
+		req.SetBasicAuth(url.QueryEscape(cc.cfg.ClientID), url.QueryEscape(cc.cfg.ClientSecret))
+	}
+
+	resp, err := cc.client.Do(req)
+	if err != nil {
+		return tr, &TokenError{TokenURL: cc.cfg.TokenURL, Err: err}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return tr, &TokenError{TokenURL: cc.cfg.TokenURL, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return tr, &TokenError{TokenURL: cc.cfg.TokenURL, StatusCode: resp.StatusCode, Err: fmt.Errorf("%s", strings.TrimSpace(string(body)))}
+	}
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return tr, &TokenError{TokenURL: cc.cfg.TokenURL, Err: err}
+	}
+	if tr.AccessToken == "" {
+		return tr, &TokenError{TokenURL: cc.cfg.TokenURL, Err: fmt.Errorf("no access token in the response")}
+	}
+	return tr, nil
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClientCredentials(t *testing.T) {
+	var calls int64
+	var fail int64
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&calls, 1)
+		if atomic.LoadInt64(&fail) == 1 {
+			http.Error(w, `{"error":"temporarily_unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method != "POST" {
+			t.Errorf("unexpected method: %s", r.Method)
+		}
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+			t.Errorf("unexpected credentials: %s, %s", id, secret)
+		}
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != "a b" || r.Form.Get("audience") != "api" {
+			t.Errorf("unexpected form: %v", r.Form)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, n)
+	}))
+	defer tokenServer.Close()
+
+	cfg := OAuth2Config{
+		TokenURL:       tokenServer.URL,
+		ClientID:       "client",
+		ClientSecret:   "secret",
+		Scopes:         []string{"a", "b"},
+		EndpointParams: map[string]string{"audience": "api"},
+	}
+	cc := NewClientCredentials(cfg)
+	if NewClientCredentials(cfg) != cc {
+		t.Error("the token source is not shared")
+	}
+
+	for i := 0; i < 3; i++ {
+		if token, err := cc.Token(); err != nil || token != "token-1" {
+			t.Errorf("#%d: unexpected token: %s, %v", i, token, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+
+	cc.Invalidate("token-0")
+	if token, err := cc.Token(); err != nil || token != "token-1" {
+		t.Errorf("unexpected token: %s, %v", token, err)
+	}
+	cc.Invalidate("token-1")
+	if token, err := cc.Token(); err != nil || token != "token-2" {
+		t.Errorf("unexpected token: %s, %v", token, err)
+	}
+
+	atomic.StoreInt64(&fail, 1)
+	cc.Invalidate("token-2")
+	token, err := cc.Token()
+	if token != "" {
+		t.Errorf("unexpected token: %s", token)
+	}
+	tokenErr, ok := err.(*TokenError)
+	if !ok {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if tokenErr.StatusCode != http.StatusServiceUnavailable || tokenErr.TokenURL != tokenServer.URL {
+		t.Errorf("unexpected error: %v", tokenErr)
+	}
+}
+
+func TestClientCredentials_endpointParams(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		fmt.Fprintf(w, `{"access_token":"token-%s","expires_in":3600}`, r.Form.Get("audience"))
+	}))
+	defer tokenServer.Close()
+
+	for _, audience := range []string{"a", "b"} {
+		cc := NewClientCredentials(OAuth2Config{
+			TokenURL:       tokenServer.URL,
+			ClientID:       "client",
+			ClientSecret:   "secret",
+			EndpointParams: map[string]string{"audience": audience},
+		})
+		if token, err := cc.Token(); err != nil || token != "token-"+audience {
+			t.Errorf("%s: unexpected token: %s, %v", audience, token, err)
+		}
+	}
+
+	cfg := OAuth2Config{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "secret"}
+	cc := NewClientCredentials(cfg)
+	cfg.AuthStyle = "params"
+	if NewClientCredentials(cfg) == cc {
+		t.Error("the token source is shared by configs with different auth styles")
+	}
+}
+
+func TestClientCredentials_expiration(t *testing.T) {
+	var calls int64
+	var fail int64
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&calls, 1)
+		if atomic.LoadInt64(&fail) == 1 {
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		r.ParseForm()
+		if r.Form.Get("client_id") != "client" || r.Form.Get("client_secret") != "secret" {
+			t.Errorf("unexpected form: %v", r.Form)
+		}
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":5}`, n)
+	}))
+	defer tokenServer.Close()
+
+	cc := NewClientCredentials(OAuth2Config{
+		TokenURL:     tokenServer.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		AuthStyle:    "params",
+	})
+
+	if token, err := cc.Token(); err != nil || token != "token-1" {
+		t.Errorf("unexpected token: %s, %v", token, err)
+	}
+	if token, err := cc.Token(); err != nil || token != "token-2" {
+		t.Errorf("the token about to expire was not refreshed: %s, %v", token, err)
+	}
+
+	atomic.StoreInt64(&fail, 1)
+	if token, err := cc.Token(); err != nil || token != "token-2" {
+		t.Errorf("the valid token was not served: %s, %v", token, err)
+	}
+	if calls != 3 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestNewBackendWithOptions_oauth2(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var tokens int64
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":3600}`, atomic.AddInt64(&tokens, 1))
+	}))
+	defer tokenServer.Close()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintln(w, "Hi")
+	}))
+	defer mockServer.Close()
+
+	backend := NewBackendWithOptions(http.DefaultClient, mockServer.URL, BackendOptions{
+		Auth: NewClientCredentials(OAuth2Config{TokenURL: tokenServer.URL}),
+	})
+
+	for i, expected := range []int{http.StatusUnauthorized, http.StatusOK, http.StatusOK} {
+		resp, err := backend(params, headers, nil)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("#%d: unexpected status code: %d", i, resp.StatusCode)
+		}
+	}
+	if tokens != 2 {
+		t.Errorf("unexpected number of tokens: %d", tokens)
+	}
+
+	tokenServer.Close()
+	backend = NewBackendWithOptions(http.DefaultClient, mockServer.URL, BackendOptions{
+		Auth: NewClientCredentials(OAuth2Config{TokenURL: tokenServer.URL, ClientID: "other"}),
+	})
+	if _, err := backend(params, headers, nil); err == nil {
+		t.Error("error expected")
+	} else if _, ok := err.(*TokenError); !ok {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -2,6 +2,9 @@ package engine
 
 import (
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -21,8 +24,7 @@ type MustachePageFactory struct {
 // Build sets up the injected gin engine and template store depending on the contents of
 // the received configuration
 func (m *MustachePageFactory) Build(cfg Config) {
-	templates, err := NewMustacheRendererMap(cfg)
-	if err != nil {
+	if err := m.loadTemplates(cfg); err != nil {
 		panic(err)
 	}
 
@@ -32,23 +34,92 @@ func (m *MustachePageFactory) Build(cfg Config) {
 
 		time.Sleep(100 * time.Millisecond)
 
-		r, ok := templates[page.Template]
-		if !ok {
-			fmt.Println("handler without template", page.Name, page.Template)
-			continue
+		m.setRenderers(page, page.Template)
+		for _, mapping := range page.StatusCodes {
+			if mapping.Template != "" {
+				m.setRenderers(page, mapping.Template)
+			}
 		}
-		m.TemplateStore.Set(page.Template, r)
-		if page.Layout == "" {
-			fmt.Println("handler without layout", page.Name, page.Layout)
-			continue
+		if page.Schema != nil && page.Schema.Template != "" {
+			m.setRenderers(page, page.Schema.Template)
 		}
-		l, ok := templates[page.Layout]
-		if !ok {
-			fmt.Println("layout not defined", page.Layout)
-			continue
+	}
+}
+
+// loadTemplates stores the declared partials, parses the declared templates and layouts into the
+// template store and sets the layouts they extend
+func (m *MustachePageFactory) loadTemplates(cfg Config) error {
+	for name, path := range cfg.Partials {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Println("reading", path, ":", err.Error())
+			return err
+		}
+		m.TemplateStore.SetPartial(name, string(data))
+	}
+	for _, section := range []map[string]string{cfg.Templates, cfg.Layouts} {
+		for name, path := range section {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Println("reading", path, ":", err.Error())
+				return err
+			}
+			if _, err := m.TemplateStore.Parse(name, cfg.Engines[name], path, data); err != nil {
+				log.Println("parsing", path, ":", err.Error())
+				return err
+			}
 		}
-		m.TemplateStore.Set(page.Layout, l)
+	}
+	for layout, parent := range cfg.Extends {
+		if _, ok := cfg.Layouts[parent]; !ok {
+			log.Println("the layout", layout, "extends an undefined layout:", parent)
+			return fmt.Errorf("layout not defined: %s", parent)
+		}
+		if err := m.TemplateStore.Extend(layout, parent); err != nil {
+			log.Println("extending the layout", parent, "with", layout, ":", err.Error())
+			return err
+		}
+	}
+	return nil
+}
 
-		m.TemplateStore.Set(fmt.Sprintf("%s-:-%s", h.Page.Layout, h.Page.Template), &LayoutMustacheRenderer{r.tmpl, l.tmpl})
+func (m *MustachePageFactory) setRenderers(page Page, template string) {
+	r, ok := m.TemplateStore.Get(template)
+	if !ok {
+		fmt.Println("handler without template", page.Name, template)
+		return
+	}
+	m.TemplateStore.Set(template, r)
+	if page.Layout == "" {
+		fmt.Println("handler without layout", page.Name, page.Layout)
+		return
+	}
+	l, ok := m.TemplateStore.Get(page.Layout)
+	if !ok {
+		fmt.Println("layout not defined", page.Layout)
+		return
+	}
+	m.TemplateStore.Set(page.Layout, l)
+
+	if err := m.TemplateStore.Compose(template, page.Layout); err != nil {
+		fmt.Println("composing", template, "with the layout", page.Layout, ":", err.Error())
+	}
+}
+
+// statusMapping returns the page response for the received backend status code and a boolean
+// signaling if the status code requires a custom page response
+func (p Page) statusMapping(code int) (StatusMapping, bool) {
+	if mapping, ok := p.StatusCodes[code]; ok {
+		if mapping.Status == 0 {
+			mapping.Status = code
+		}
+		return mapping, true
+	}
+	switch {
+	case code == http.StatusNotFound:
+		return StatusMapping{Status: http.StatusNotFound}, true
+	case code >= http.StatusInternalServerError:
+		return StatusMapping{Status: http.StatusInternalServerError}, true
 	}
+	return StatusMapping{}, false
 }
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchPartials updates the partials of the template store every time their files, declared as a
+// map of names and paths, change. The folders of the files are watched, so the editors replacing
+// the files instead of writing them are supported too. Closing the returned watcher stops it
+func WatchPartials(ts *TemplateStore, files map[string]string) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	folders := map[string]bool{}
+	for name, path := range files {
+		path = filepath.Clean(path)
+		names[path] = name
+		folder := filepath.Dir(path)
+		if folders[folder] {
+			continue
+		}
+		if err := watcher.Add(folder); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		folders[folder] = true
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				name, ok := names[filepath.Clean(event.Name)]
+				if !ok || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				data, err := ioutil.ReadFile(event.Name)
+				if err != nil {
+					log.Println("reading the partial", name, ":", err.Error())
+					continue
+				}
+				log.Println("updating the partial", name)
+				if err := ts.SetPartial(name, string(data)); err != nil {
+					log.Println("updating the partial", name, ":", err.Error())
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Println("watching the partials:", err.Error())
+			}
+		}
+	}()
+
+	return watcher, nil
+}
@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchPartials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api2html-partials")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "header.mustache")
+	if err := ioutil.WriteFile(path, []byte("<h1>{{title}}</h1>"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ts := NewTemplateStore()
+	ts.SetPartial("header", "<h1>{{title}}</h1>")
+	if _, err := ts.Parse("home", "", "", []byte("{{> header}}")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	watcher, err := WatchPartials(ts, map[string]string{"header": path})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer watcher.Close()
+
+	in := make(chan Renderer, 1)
+	ts.Subscribe <- Subscription{"home", in}
+	time.Sleep(100 * time.Millisecond)
+
+	// editors usually replace the file instead of writing it
+	tmp := filepath.Join(dir, "header.mustache.tmp")
+	if err := ioutil.WriteFile(tmp, []byte("<h2>{{title}}</h2>"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Error(err)
+		return
+	}
+
+	select {
+	case r := <-in:
+		w := &bytes.Buffer{}
+		if err := r.Render(w, map[string]interface{}{"title": "a"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if w.String() != "<h2>a</h2>" {
+			t.Errorf("unexpected result: %s", w.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("the renderer was not updated")
+	}
+}
+
+func TestWatchPartials_ko(t *testing.T) {
+	if _, err := WatchPartials(NewTemplateStore(), map[string]string{"header": "/unknown/folder/header.mustache"}); err == nil {
+		t.Error("expecting error")
+	}
+}
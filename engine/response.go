@@ -2,8 +2,10 @@ package engine
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
-	"time"
+	"net/http"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	newrelic "github.com/newrelic/go-agent"
@@ -16,10 +18,21 @@ type ResponseContext struct {
 	Data map[string]interface{}
 	// Array cotains the backend data if the response was decoded as an array
 	Array []map[string]interface{}
+	// Value contains the decoded backend data, whatever its shape (objects, arrays of any kind,
+	// strings, numbers or booleans)
+	Value interface{}
 	// Extra contains the extra data injected from the config
 	Extra map[string]interface{}
 	// Params stores the params of the request
 	Params map[string]string
+	// Query stores the query string params of the request
+	Query map[string]string
+	// Headers stores the request headers exposed by the page
+	Headers map[string]string
+	// Cookies stores the cookies of the request
+	Cookies map[string]string
+	// Backends contains the decoded responses of the named backends, indexed by backend name
+	Backends map[string]interface{}
 	// Helper is a struct containing a few basic template helpers
 	Helper interface{} `json:"-"`
 	// 	Context is a reference to the gin context for the request
@@ -56,26 +69,76 @@ func (s *StaticResponseGenerator) ResponseGenerator(c *gin.Context) (ResponseCon
 	if newrelicApp != nil {
 		defer newrelic.StartSegment(nrgin.Transaction(c), "Request manipulation").End()
 	}
+	return newResponseContext(s.Page, c), nil
+}
+
+// newResponseContext returns a ResponseContext with the default response values for the page
+// and the details of the request
+func newResponseContext(page Page, c *gin.Context) ResponseContext {
 	params := map[string]string{}
 	for _, v := range c.Params {
 		params[v.Key] = v.Value
 	}
-	target := ResponseContext{
-		Extra:   s.Page.Extra,
+	query := map[string]string{}
+	for k, vs := range c.Request.URL.Query() {
+		query[k] = vs[0]
+	}
+	headers := map[string]string{}
+	for _, k := range page.ExposedHeaders {
+		if v := c.Request.Header.Get(k); v != "" {
+			headers[k] = v
+		}
+	}
+	cookies := map[string]string{}
+	for _, cookie := range c.Request.Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+	helper := page.helper
+	if helper == nil {
+		helper = defaultHelper
+	}
+	return ResponseContext{
+		Extra:   page.Extra,
 		Context: c,
 		Params:  params,
-		Helper:  &tplHelper{},
+		Query:   query,
+		Headers: headers,
+		Cookies: cookies,
+		Helper:  helper,
 	}
-	return target, nil
 }
 
 // DynamicResponseGenerator is a ResponseGenerator that creates a response by adding the decoded data
-// returned by the Backend wo the default response values. Depending on the selected decoder,
-// the generated responses may have the backend data stored at the `Obj` or at the `Arr` part
+// returned by the Backend wo the default response values. Depending on the selected decoder and on
+// the shape of the backend data, the generated responses may have it stored at the `Data`, at the
+// `Array` or just at the `Value` part.
+// The named Backends are called concurrently and their decoded data is stored at the `Backends`
+// part, under the name of each backend. Then, the backends of the Chain are called in order, so
+// they can reference values of the previous responses.
+// Finally, the decoded data the template receives is checked by the Schema validator, if any
 type DynamicResponseGenerator struct {
-	Page    Page
-	Backend Backend
-	Decoder Decoder
+	Page      Page
+	Backend   Backend
+	Decoder   Decoder
+	Backends  []NamedBackend
+	Chain     []NamedBackend
+	Stale     *StaleCache
+	Coalescer *Coalescer
+	Schema    *SchemaValidator
+}
+
+// NamedBackend is a Backend with its own Decoder and a name to use as key when adding the decoded
+// response into the ResponseContext
+type NamedBackend struct {
+	Name      string
+	Backend   Backend
+	Decoder   Decoder
+	Optional  bool
+	Stale     *StaleCache
+	Coalescer *Coalescer
+	// References is the list of values of the previous responses used by a chained backend
+	// (ex: "Data.author_id"). They are added to the params under the same name
+	References []string
 }
 
 // ResponseGenerator implements the ResponseGenerator interface
@@ -85,41 +148,226 @@ func (drg *DynamicResponseGenerator) ResponseGenerator(c *gin.Context) (Response
 		segment = newrelic.StartSegment(nrgin.Transaction(c), "Request manipulation")
 	}
 
-	params := map[string]string{}
-	for _, v := range c.Params {
-		params[v.Key] = v.Value
-	}
 	headers := map[string]string{}
 	h := c.Request.Header.Get(drg.Page.Header)
 	if h != "" {
 		headers[drg.Page.Header] = h
 	}
-	result := ResponseContext{
-		Extra:   drg.Page.Extra,
-		Context: c,
-		Params:  params,
-		Helper:  &tplHelper{},
-	}
+	result := newResponseContext(drg.Page, c)
+	params := backendParams(drg.Page, result.Params, c.Request)
 	segment.End()
 
-	resp, err := drg.Backend(params, headers, c)
+	responses := make([]ResponseContext, len(drg.Backends))
+	errs := make([]error, len(drg.Backends))
+	var wg sync.WaitGroup
+	for i, nb := range drg.Backends {
+		wg.Add(1)
+		go func(i int, nb NamedBackend) {
+			errs[i] = nb.load(params, headers, c, &responses[i])
+			wg.Done()
+		}(i, nb)
+	}
+
+	var err error
+	if drg.Backend != nil {
+		err = drg.load(params, headers, c, &result)
+	}
+	wg.Wait()
 	if err != nil {
 		return result, err
 	}
 
-	if newrelicApp != nil {
-		segment = newrelic.StartSegment(nrgin.Transaction(c), "Decoder")
+	for i, nb := range drg.Backends {
+		if errs[i] != nil {
+			if !nb.Optional {
+				return result, errs[i]
+			}
+			log.Println("optional backend", nb.Name, ":", errs[i].Error())
+			continue
+		}
+		result.setBackend(nb.Name, responses[i])
 	}
-	err = drg.Decoder(resp.Body, &result)
-	resp.Body.Close()
-	segment.End()
 
-	return result, err
+	for _, nb := range drg.Chain {
+		r := ResponseContext{}
+		if err := nb.loadChained(params, headers, c, result, &r); err != nil {
+			if !nb.Optional {
+				return result, err
+			}
+			log.Println("optional backend", nb.Name, ":", err.Error())
+			continue
+		}
+		result.setBackend(nb.Name, r)
+	}
+
+	return result, drg.Schema.Validate(schemaData(result))
+}
+
+// schemaData returns the decoded data of the response as the templates receive it: the data of the
+// main backend, with the one of the named and chained backends under the Backends key. The main
+// data is stored under the Value key when it is not an object
+func schemaData(r ResponseContext) interface{} {
+	v := decodedValue(r)
+	if len(r.Backends) == 0 {
+		return v
+	}
+	data := map[string]interface{}{}
+	if obj, ok := v.(map[string]interface{}); ok {
+		for k, x := range obj {
+			data[k] = x
+		}
+	} else if v != nil {
+		data["Value"] = v
+	}
+	data["Backends"] = r.Backends
+	return data
+}
+
+// setBackend stores the decoded response of a named backend under its name
+func (r *ResponseContext) setBackend(name string, resp ResponseContext) {
+	if r.Backends == nil {
+		r.Backends = map[string]interface{}{}
+	}
+	switch {
+	case resp.Array != nil:
+		r.Backends[name] = resp.Array
+	case resp.Data != nil:
+		r.Backends[name] = resp.Data
+	default:
+		r.Backends[name] = resp.Value
+	}
+}
+
+// backendParams returns a copy of the request params extended with the query string params and
+// cookies mapped to placeholders by the page
+func backendParams(page Page, params map[string]string, r *http.Request) map[string]string {
+	result := make(map[string]string, len(params)+len(page.QueryParams)+len(page.Cookies))
+	for k, v := range params {
+		result[k] = v
+	}
+	query := r.URL.Query()
+	for name, placeholder := range page.QueryParams {
+		result[placeholder] = query.Get(name)
+	}
+	for name, placeholder := range page.Cookies {
+		result[placeholder] = ""
+		if cookie, err := r.Cookie(name); err == nil {
+			result[placeholder] = cookie.Value
+		}
+	}
+	return result
+}
+
+// load fetches the main backend response through the stale cache and the coalescer
+func (drg *DynamicResponseGenerator) load(params, headers map[string]string, c *gin.Context, result *ResponseContext) error {
+	return drg.Stale.Fetch(params, headers, c, result, func(c *gin.Context, r *ResponseContext) error {
+		return drg.Coalescer.Fetch(params, headers, c, r, func(c *gin.Context, r *ResponseContext) error {
+			return drg.fetch(params, headers, c, r)
+		})
+	})
 }
 
-type tplHelper struct {
+func (drg *DynamicResponseGenerator) fetch(params, headers map[string]string, c *gin.Context, result *ResponseContext) error {
+	resp, err := drg.Backend(params, headers, c)
+	if err != nil {
+		return err
+	}
+
+	mapping, ok := drg.Page.statusMapping(resp.StatusCode)
+	if !ok {
+		return decode(drg.Decoder, resp, c, result)
+	}
+
+	statusErr := &StatusError{
+		StatusCode: mapping.Status,
+		Template:   mapping.Template,
+	}
+	if mapping.Location != "" {
+		statusErr.Location = string(replaceParamsWithRaw([]byte(mapping.Location), params, nil))
+	}
+	if mapping.Template == "" {
+		resp.Body.Close()
+		return statusErr
+	}
+	if err := decode(drg.Decoder, resp, c, result); err != nil {
+		log.Println("decoding the backend response with status", resp.StatusCode, ":", err.Error())
+	}
+	return statusErr
 }
 
-func (tplHelper) Now() string {
-	return time.Now().String()
+// load fetches the backend response through the stale cache and the coalescer
+func (nb NamedBackend) load(params, headers map[string]string, c *gin.Context, result *ResponseContext) error {
+	return nb.Stale.Fetch(params, headers, c, result, func(c *gin.Context, r *ResponseContext) error {
+		return nb.Coalescer.Fetch(params, headers, c, r, func(c *gin.Context, r *ResponseContext) error {
+			return nb.fetch(params, headers, c, r)
+		})
+	})
+}
+
+// loadChained fetches the backend response, adding the values referenced by the backend from the
+// previous responses to the params
+func (nb NamedBackend) loadChained(params, headers map[string]string, c *gin.Context, previous ResponseContext, result *ResponseContext) error {
+	if len(nb.References) == 0 {
+		return nb.load(params, headers, c, result)
+	}
+	stepParams := make(map[string]string, len(params)+len(nb.References))
+	for k, v := range params {
+		stepParams[k] = v
+	}
+	for _, ref := range nb.References {
+		v, err := referenceValue(previous, ref)
+		if err != nil {
+			return fmt.Errorf("backend %s: %s", nb.Name, err.Error())
+		}
+		stepParams[ref] = v
+	}
+	return nb.load(stepParams, headers, c, result)
+}
+
+// referenceValue returns the value at the referenced path of the response. The references start
+// with "Data", for the response of the main backend, or with "Backends", for the named ones
+func referenceValue(r ResponseContext, ref string) (string, error) {
+	path := splitPath(ref)
+	var v interface{}
+	switch path[0] {
+	case "Data":
+		v = lookupPath(decodedValue(r), path[1:])
+	case "Backends":
+		v = lookupPath(r.Backends, path[1:])
+	}
+	switch t := v.(type) {
+	case nil:
+		return "", fmt.Errorf("missing value for %s", ref)
+	case string:
+		return t, nil
+	case json.Number:
+		return t.String(), nil
+	case bool, float64, int, int64:
+		return fmt.Sprint(t), nil
+	}
+	return "", fmt.Errorf("the value of %s is not a scalar", ref)
+}
+
+func (nb NamedBackend) fetch(params, headers map[string]string, c *gin.Context, result *ResponseContext) error {
+	resp, err := nb.Backend(params, headers, c)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		resp.Body.Close()
+		return fmt.Errorf("backend %s responded with the status code %d", nb.Name, resp.StatusCode)
+	}
+	return decode(nb.Decoder, resp, c, result)
+}
+
+func decode(d Decoder, resp *http.Response, c *gin.Context, result *ResponseContext) error {
+	var segment newrelic.Segment
+	if newrelicApp != nil {
+		segment = newrelic.StartSegment(nrgin.Transaction(c), "Decoder")
+	}
+	err := d(&responseBody{resp.Body, resp.Header}, result)
+	resp.Body.Close()
+	segment.End()
+
+	return err
 }
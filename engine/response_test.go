@@ -32,13 +32,18 @@ func ExampleResponseContext_String() {
 	// 		"b": 42
 	// 	},
 	// 	"Array": null,
+	// 	"Value": null,
 	// 	"Extra": {
 	// 		"extra1": "foo",
 	// 		"extra2": 42
 	// 	},
 	// 	"Params": {
 	// 		"p1": "v1"
-	// 	}
+	// 	},
+	// 	"Query": null,
+	// 	"Headers": null,
+	// 	"Cookies": null,
+	// 	"Backends": null
 	// }
 }
 
@@ -206,6 +211,323 @@ func TestDynamicResponseGenerator_ok(t *testing.T) {
 	}
 }
 
+func TestDynamicResponseGenerator_namedBackends(t *testing.T) {
+	optionalErr := fmt.Errorf("optionalErr")
+	newBackend := func(body string) Backend {
+		return func(_ map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+			return &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(body))}, nil
+		}
+	}
+	subject := DynamicResponseGenerator{
+		Page:    Page{Extra: map[string]interface{}{"a": 42.0}},
+		Backend: newBackend(`{"a":true}`),
+		Decoder: JSONDecoder,
+		Backends: []NamedBackend{
+			{
+				Name:    "reviews",
+				Backend: newBackend(`[{"b":1}]`),
+				Decoder: JSONArrayDecoder,
+			},
+			{
+				Name:    "stock",
+				Backend: newBackend(`{"c":"d"}`),
+				Decoder: JSONDecoder,
+			},
+			{
+				Name: "optional",
+				Backend: func(_ map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+					return nil, optionalErr
+				},
+				Decoder:  JSONDecoder,
+				Optional: true,
+			},
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.GET("/:first/:second", func(c *gin.Context) {
+		resp, err := subject.ResponseGenerator(c)
+		if err != nil {
+			t.Error("unexpected error:", err.Error())
+			return
+		}
+		checkCommonResponseProperties(t, resp)
+
+		if d, ok := resp.Data["a"].(bool); !ok || !d {
+			t.Errorf("unexpected response. data: %v", resp.Data)
+		}
+		if len(resp.Backends) != 2 {
+			t.Errorf("unexpected response. backends: %v", resp.Backends)
+		}
+		if reviews, ok := resp.Backends["reviews"].([]map[string]interface{}); !ok || len(reviews) != 1 {
+			t.Errorf("unexpected response. reviews: %v", resp.Backends["reviews"])
+		}
+		if stock, ok := resp.Backends["stock"].(map[string]interface{}); !ok || stock["c"] != "d" {
+			t.Errorf("unexpected response. stock: %v", resp.Backends["stock"])
+		}
+		c.Status(200)
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/foo/bar", nil)
+	e.ServeHTTP(w, r)
+	if w.Result().StatusCode != 200 {
+		t.Errorf("unexpected status code: %d", w.Result().StatusCode)
+	}
+}
+
+func TestDynamicResponseGenerator_schemaNamedBackends(t *testing.T) {
+	sv, err := NewSchemaValidator("schema_named_backends", SchemaConfig{
+		Schema: map[string]interface{}{
+			"required": []interface{}{"Backends"},
+			"properties": map[string]interface{}{
+				"Backends": map[string]interface{}{
+					"required": []interface{}{"stock"},
+					"properties": map[string]interface{}{
+						"stock": map[string]interface{}{
+							"properties": map[string]interface{}{"c": map[string]interface{}{"type": "number"}},
+						},
+					},
+				},
+			},
+		},
+		OnViolation: SchemaFail,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	gin.SetMode(gin.TestMode)
+	for i, tc := range []struct {
+		body string
+		err  error
+	}{
+		{`{"c":42}`, nil},
+		{`{"c":"d"}`, &SchemaError{[]string{"/Backends/stock/c: expected number, got string"}}},
+	} {
+		subject := DynamicResponseGenerator{
+			Backends: []NamedBackend{
+				{
+					Name: "stock",
+					Backend: func(_ map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+						return &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(tc.body))}, nil
+					},
+					Decoder: JSONDecoder,
+				},
+			},
+			Schema: sv,
+		}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/", nil)
+		if _, err := subject.ResponseGenerator(c); fmt.Sprintf("%v", err) != fmt.Sprintf("%v", tc.err) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestDynamicResponseGenerator_koNamedBackend(t *testing.T) {
+	backendErr := fmt.Errorf("backendErr")
+	subject := DynamicResponseGenerator{
+		Page: Page{Extra: map[string]interface{}{"a": 42.0}},
+		Backends: []NamedBackend{
+			{
+				Name: "required",
+				Backend: func(_ map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+					return nil, backendErr
+				},
+				Decoder: JSONDecoder,
+			},
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.GET("/:first/:second", func(c *gin.Context) {
+		_, err := subject.ResponseGenerator(c)
+		if err != backendErr {
+			t.Error("unexpected error:", err)
+			return
+		}
+		c.Status(200)
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/foo/bar", nil)
+	e.ServeHTTP(w, r)
+	if w.Result().StatusCode != 200 {
+		t.Errorf("unexpected status code: %d", w.Result().StatusCode)
+	}
+}
+
+func TestDynamicResponseGenerator_queryAndCookieParams(t *testing.T) {
+	subject := DynamicResponseGenerator{
+		Page: Page{
+			Extra:       map[string]interface{}{"a": 42.0},
+			QueryParams: map[string]string{"q": "term", "missing": "absent"},
+			Cookies:     map[string]string{"lang": "language"},
+		},
+		Decoder: JSONDecoder,
+		Backend: func(params map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+			if params["first"] != "foo" || params["second"] != "bar" {
+				t.Error("unexpected params:", params)
+			}
+			if params["term"] != "some thing" || params["language"] != "es" {
+				t.Error("unexpected params:", params)
+			}
+			if v, ok := params["absent"]; !ok || v != "" {
+				t.Error("unexpected params:", params)
+			}
+			return &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString("{}"))}, nil
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.GET("/:first/:second", func(c *gin.Context) {
+		resp, err := subject.ResponseGenerator(c)
+		if err != nil {
+			t.Error("unexpected error:", err.Error())
+			return
+		}
+		checkCommonResponseProperties(t, resp)
+		if len(resp.Params) != 2 {
+			t.Errorf("unexpected response. params: %v", resp.Params)
+		}
+		c.Status(200)
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/foo/bar?q=some+thing", nil)
+	r.AddCookie(&http.Cookie{Name: "lang", Value: "es"})
+	e.ServeHTTP(w, r)
+	if w.Result().StatusCode != 200 {
+		t.Errorf("unexpected status code: %d", w.Result().StatusCode)
+	}
+}
+
+func TestStaticResponseGenerator_requestDetails(t *testing.T) {
+	subject := StaticResponseGenerator{Page{
+		Extra:          map[string]interface{}{"a": 42.0},
+		ExposedHeaders: []string{"Accept-Language", "X-Missing"},
+	}}
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.GET("/:first/:second", func(c *gin.Context) {
+		resp, err := subject.ResponseGenerator(c)
+		if err != nil {
+			t.Error("unexpected error:", err.Error())
+			return
+		}
+		checkCommonResponseProperties(t, resp)
+		if len(resp.Query) != 2 || resp.Query["q"] != "laptops" || resp.Query["page"] != "2" {
+			t.Errorf("unexpected response. query: %v", resp.Query)
+		}
+		if len(resp.Headers) != 1 || resp.Headers["Accept-Language"] != "es-ES" {
+			t.Errorf("unexpected response. headers: %v", resp.Headers)
+		}
+		if len(resp.Cookies) != 1 || resp.Cookies["theme"] != "dark" {
+			t.Errorf("unexpected response. cookies: %v", resp.Cookies)
+		}
+		c.Status(200)
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/foo/bar?q=laptops&page=2", nil)
+	r.Header.Set("Accept-Language", "es-ES")
+	r.Header.Set("User-Agent", "test")
+	r.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+	e.ServeHTTP(w, r)
+	if w.Result().StatusCode != 200 {
+		t.Errorf("unexpected status code: %d", w.Result().StatusCode)
+	}
+}
+
+func TestDynamicResponseGenerator_statusCodes(t *testing.T) {
+	for i, tc := range []struct {
+		mappings map[int]StatusMapping
+		status   int
+		expected *StatusError
+		decoded  bool
+	}{
+		{nil, 200, nil, true},
+		{nil, 400, nil, true},
+		{nil, 404, &StatusError{StatusCode: 404}, false},
+		{nil, 503, &StatusError{StatusCode: 500}, false},
+		{map[int]StatusMapping{410: {}}, 410, &StatusError{StatusCode: 410}, false},
+		{map[int]StatusMapping{301: {}}, 301, &StatusError{StatusCode: 301}, false},
+		{map[int]StatusMapping{301: {Location: "/products/:id"}}, 301, &StatusError{StatusCode: 301, Location: "/products/a%20b"}, false},
+		{map[int]StatusMapping{404: {Template: "not_found"}}, 404, &StatusError{StatusCode: 404, Template: "not_found"}, true},
+		{map[int]StatusMapping{503: {Status: 200, Template: "maintenance"}}, 503, &StatusError{StatusCode: 200, Template: "maintenance"}, true},
+	} {
+		subject := DynamicResponseGenerator{
+			Page:    Page{StatusCodes: tc.mappings},
+			Decoder: JSONDecoder,
+			Backend: func(_ map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+				resp := &http.Response{
+					StatusCode: tc.status,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(bytes.NewBufferString(`{"a":true}`)),
+				}
+				if tc.status == 301 {
+					resp.Header.Set("Location", "http://internal.example.com/new")
+				}
+				return resp, nil
+			},
+		}
+		gin.SetMode(gin.TestMode)
+		e := gin.New()
+		e.GET("/:id", func(c *gin.Context) {
+			resp, err := subject.ResponseGenerator(c)
+			if tc.expected == nil {
+				if err != nil {
+					t.Errorf("#%d: unexpected error: %s", i, err.Error())
+				}
+			} else if statusErr, ok := err.(*StatusError); !ok || *statusErr != *tc.expected {
+				t.Errorf("#%d: unexpected error: %v", i, err)
+			}
+			if _, ok := resp.Data["a"]; ok != tc.decoded {
+				t.Errorf("#%d: unexpected response. data: %v", i, resp.Data)
+			}
+			c.Status(200)
+		})
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/a%20b", nil)
+		e.ServeHTTP(w, r)
+		if w.Result().StatusCode != 200 {
+			t.Errorf("#%d: unexpected status code: %d", i, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestDynamicResponseGenerator_koNamedBackendStatus(t *testing.T) {
+	subject := DynamicResponseGenerator{
+		Page: Page{},
+		Backends: []NamedBackend{
+			{
+				Name: "required",
+				Backend: func(_ map[string]string, _ map[string]string, _ *gin.Context) (*http.Response, error) {
+					return &http.Response{StatusCode: 500, Body: ioutil.NopCloser(bytes.NewBufferString(""))}, nil
+				},
+				Decoder: JSONDecoder,
+			},
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.GET("/", func(c *gin.Context) {
+		if _, err := subject.ResponseGenerator(c); err == nil {
+			t.Error("error expected")
+		}
+		c.Status(200)
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	e.ServeHTTP(w, r)
+	if w.Result().StatusCode != 200 {
+		t.Errorf("unexpected status code: %d", w.Result().StatusCode)
+	}
+}
+
 func checkCommonResponseProperties(t *testing.T, resp ResponseContext) {
 	if 42.0 != resp.Extra["a"].(float64) {
 		t.Errorf("unexpected response. extra: %v", resp.Extra)
@@ -0,0 +1,575 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The behaviours of a SchemaValidator when the decoded data violates the schema
+const (
+	// SchemaLog logs the violations and renders the page as usual
+	SchemaLog = "log"
+	// SchemaFail aborts the request with a 500 status code
+	SchemaFail = "fail"
+	// SchemaFallback renders the fallback template with the decoded data
+	SchemaFallback = "fallback"
+)
+
+var schemaValidators = &sync.Map{}
+
+// SchemaValidators returns the counters of all the registered schema validators, indexed by name
+func SchemaValidators() map[string]SchemaValidatorState {
+	result := map[string]SchemaValidatorState{}
+	schemaValidators.Range(func(k, v interface{}) bool {
+		result[k.(string)] = v.(*SchemaValidator).State()
+		return true
+	})
+	return result
+}
+
+// SchemaError is the error returned when the decoded data violates the schema
+type SchemaError struct {
+	Violations []string
+}
+
+// Error implements the error interface
+func (e *SchemaError) Error() string {
+	return "schema violations: " + strings.Join(e.Violations, "; ")
+}
+
+// NewSchemaValidator creates a SchemaValidator with the received config and registers it with the
+// received name, so its counters can be monitored
+func NewSchemaValidator(name string, cfg SchemaConfig) (*SchemaValidator, error) {
+	raw := cfg.Schema
+	if raw == nil {
+		if cfg.File == "" {
+			return nil, fmt.Errorf("no schema defined")
+		}
+		data, err := ioutil.ReadFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	}
+	schema, err := compileSchema(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := cfg.OnViolation
+	switch mode {
+	case "":
+		mode = SchemaLog
+	case SchemaLog, SchemaFail:
+	case SchemaFallback:
+		if cfg.Template == "" {
+			return nil, fmt.Errorf("no fallback template defined")
+		}
+	default:
+		return nil, fmt.Errorf("unknown schema violation mode: %s", mode)
+	}
+	status := cfg.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	sv := &SchemaValidator{
+		name:     name,
+		schema:   schema,
+		mode:     mode,
+		template: cfg.Template,
+		status:   status,
+		mutex:    &sync.Mutex{},
+	}
+	schemaValidators.Store(name, sv)
+	return sv, nil
+}
+
+// SchemaValidator checks the decoded data against a JSON Schema, counting the violations
+type SchemaValidator struct {
+	name            string
+	schema          *schema
+	mode            string
+	template        string
+	status          int
+	validations     int
+	violations      int
+	lastViolation   string
+	lastViolationAt time.Time
+	mutex           *sync.Mutex
+}
+
+// SchemaValidatorState is a snapshot of the counters of a SchemaValidator
+type SchemaValidatorState struct {
+	Validations     int       `json:"validations"`
+	Violations      int       `json:"violations"`
+	LastViolation   string    `json:"last_violation,omitempty"`
+	LastViolationAt time.Time `json:"last_violation_at"`
+}
+
+// State returns a snapshot of the counters of the schema validator
+func (sv *SchemaValidator) State() SchemaValidatorState {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+	return SchemaValidatorState{sv.validations, sv.violations, sv.lastViolation, sv.lastViolationAt}
+}
+
+// Validate checks the received value against the schema. Depending on the configured mode, the
+// violations are just logged or returned as a *SchemaError or as a *StatusError pointing to the
+// fallback template
+func (sv *SchemaValidator) Validate(v interface{}) error {
+	if sv == nil {
+		return nil
+	}
+	violations := sv.schema.validate(v, "", nil)
+
+	sv.mutex.Lock()
+	sv.validations++
+	if len(violations) > 0 {
+		sv.violations++
+		sv.lastViolation = violations[0]
+		sv.lastViolationAt = time.Now()
+	}
+	sv.mutex.Unlock()
+
+	if len(violations) == 0 {
+		return nil
+	}
+	err := &SchemaError{violations}
+	log.Println("validating the data of", sv.name, ":", err.Error())
+	switch sv.mode {
+	case SchemaFail:
+		return err
+	case SchemaFallback:
+		return &StatusError{StatusCode: sv.status, Template: sv.template}
+	}
+	return nil
+}
+
+// schema is a compiled JSON Schema. Only the validation keywords are supported: type, enum, const,
+// the numeric and string limits, pattern, items, the array limits, properties, required,
+// additionalProperties, allOf, anyOf, oneOf and not. The schemas using any other keyword, apart
+// from the annotations, are rejected
+type schema struct {
+	never                bool
+	types                []string
+	enum                 []interface{}
+	constant             []interface{}
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	multipleOf           *float64
+	minLength            *float64
+	maxLength            *float64
+	pattern              *regexp.Regexp
+	items                *schema
+	minItems             *float64
+	maxItems             *float64
+	properties           map[string]*schema
+	required             []string
+	additionalProperties *schema
+	allOf                []*schema
+	anyOf                []*schema
+	oneOf                []*schema
+	not                  *schema
+}
+
+var schemaTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true,
+}
+
+func compileSchema(raw interface{}) (*schema, error) {
+	switch t := raw.(type) {
+	case bool:
+		return &schema{never: !t}, nil
+	case map[string]interface{}:
+		return compileSchemaObject(t)
+	}
+	return nil, fmt.Errorf("invalid schema: %v", raw)
+}
+
+// schemaKeywords are the keywords accepted by compileSchemaObject: the supported validation keywords
+// and the annotations, ignored by the validation
+var schemaKeywords = map[string]bool{
+	"type": true, "enum": true, "const": true, "minimum": true, "maximum": true,
+	"exclusiveMinimum": true, "exclusiveMaximum": true, "multipleOf": true, "minLength": true,
+	"maxLength": true, "pattern": true, "items": true, "minItems": true, "maxItems": true,
+	"properties": true, "required": true, "additionalProperties": true, "allOf": true, "anyOf": true,
+	"oneOf": true, "not": true,
+
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "format": true, "readOnly": true, "writeOnly": true,
+}
+
+func compileSchemaObject(raw map[string]interface{}) (*schema, error) {
+	s := &schema{}
+	var err error
+
+	unsupported := []string{}
+	for keyword := range raw {
+		if !schemaKeywords[keyword] {
+			unsupported = append(unsupported, keyword)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return nil, fmt.Errorf("unsupported keywords: %s", strings.Join(unsupported, ", "))
+	}
+
+	switch t := raw["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid type: %v", v)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("invalid type: %v", t)
+	}
+	for _, name := range s.types {
+		if !schemaTypes[name] {
+			return nil, fmt.Errorf("unknown type: %s", name)
+		}
+	}
+
+	if v, ok := raw["enum"]; ok {
+		if s.enum, ok = v.([]interface{}); !ok {
+			return nil, fmt.Errorf("invalid enum: %v", v)
+		}
+	}
+	if v, ok := raw["const"]; ok {
+		s.constant = []interface{}{v}
+	}
+
+	for keyword, dst := range map[string]**float64{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum,
+		"exclusiveMaximum": &s.exclusiveMaximum,
+		"multipleOf":       &s.multipleOf,
+		"minLength":        &s.minLength,
+		"maxLength":        &s.maxLength,
+		"minItems":         &s.minItems,
+		"maxItems":         &s.maxItems,
+	} {
+		v, ok := raw[keyword]
+		if !ok {
+			continue
+		}
+		f, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: %v", keyword, v)
+		}
+		*dst = &f
+	}
+
+	if v, ok := raw["pattern"]; ok {
+		p, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid pattern: %v", v)
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, err
+		}
+	}
+
+	for keyword, dst := range map[string]**schema{
+		"items":                &s.items,
+		"additionalProperties": &s.additionalProperties,
+		"not":                  &s.not,
+	} {
+		v, ok := raw[keyword]
+		if !ok {
+			continue
+		}
+		if *dst, err = compileSchema(v); err != nil {
+			return nil, fmt.Errorf("%s: %s", keyword, err.Error())
+		}
+	}
+
+	if v, ok := raw["properties"]; ok {
+		properties, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid properties: %v", v)
+		}
+		s.properties = make(map[string]*schema, len(properties))
+		for name, p := range properties {
+			if s.properties[name], err = compileSchema(p); err != nil {
+				return nil, fmt.Errorf("property %s: %s", name, err.Error())
+			}
+		}
+	}
+
+	if v, ok := raw["required"]; ok {
+		required, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid required: %v", v)
+		}
+		for _, r := range required {
+			name, ok := r.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid required: %v", r)
+			}
+			s.required = append(s.required, name)
+		}
+	}
+
+	for keyword, dst := range map[string]*[]*schema{
+		"allOf": &s.allOf,
+		"anyOf": &s.anyOf,
+		"oneOf": &s.oneOf,
+	} {
+		v, ok := raw[keyword]
+		if !ok {
+			continue
+		}
+		list, ok := v.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("invalid %s: %v", keyword, v)
+		}
+		for i, item := range list {
+			sub, err := compileSchema(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s #%d: %s", keyword, i, err.Error())
+			}
+			*dst = append(*dst, sub)
+		}
+	}
+
+	return s, nil
+}
+
+// validate appends the violations of the value at the received JSON pointer to the list
+func (s *schema) validate(v interface{}, path string, violations []string) []string {
+	if s.never {
+		return append(violations, violation(path, "no value allowed"))
+	}
+	if array, ok := v.([]map[string]interface{}); ok {
+		v = decodedValue(ResponseContext{Array: array})
+	}
+
+	if len(s.types) > 0 && !hasSchemaType(v, s.types) {
+		return append(violations, violation(path, "expected %s, got %s", strings.Join(s.types, " or "), schemaType(v)))
+	}
+	if s.enum != nil && !containsValue(s.enum, v) {
+		violations = append(violations, violation(path, "value not allowed: %v", v))
+	}
+	if s.constant != nil && !containsValue(s.constant, v) {
+		violations = append(violations, violation(path, "expected %v, got %v", s.constant[0], v))
+	}
+
+	switch t := v.(type) {
+	case string:
+		violations = s.validateString(t, path, violations)
+	case []interface{}:
+		violations = s.validateArray(t, path, violations)
+	case map[string]interface{}:
+		violations = s.validateObject(t, path, violations)
+	default:
+		if f, ok := toFloat(v); ok {
+			violations = s.validateNumber(f, path, violations)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		violations = sub.validate(v, path, violations)
+	}
+	if len(s.anyOf) > 0 && countValid(s.anyOf, v) == 0 {
+		violations = append(violations, violation(path, "no schema of anyOf matched"))
+	}
+	if len(s.oneOf) > 0 {
+		if n := countValid(s.oneOf, v); n != 1 {
+			violations = append(violations, violation(path, "%d schemas of oneOf matched", n))
+		}
+	}
+	if s.not != nil && len(s.not.validate(v, path, nil)) == 0 {
+		violations = append(violations, violation(path, "the value matches the not schema"))
+	}
+	return violations
+}
+
+func (s *schema) validateNumber(f float64, path string, violations []string) []string {
+	if s.minimum != nil && f < *s.minimum {
+		violations = append(violations, violation(path, "%v is lower than %v", f, *s.minimum))
+	}
+	if s.maximum != nil && f > *s.maximum {
+		violations = append(violations, violation(path, "%v is greater than %v", f, *s.maximum))
+	}
+	if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+		violations = append(violations, violation(path, "%v is not greater than %v", f, *s.exclusiveMinimum))
+	}
+	if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+		violations = append(violations, violation(path, "%v is not lower than %v", f, *s.exclusiveMaximum))
+	}
+	if s.multipleOf != nil && *s.multipleOf != 0 {
+		if q := f / *s.multipleOf; q != math.Trunc(q) {
+			violations = append(violations, violation(path, "%v is not a multiple of %v", f, *s.multipleOf))
+		}
+	}
+	return violations
+}
+
+func (s *schema) validateString(str string, path string, violations []string) []string {
+	length := float64(utf8.RuneCountInString(str))
+	if s.minLength != nil && length < *s.minLength {
+		violations = append(violations, violation(path, "shorter than %v characters", *s.minLength))
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		violations = append(violations, violation(path, "longer than %v characters", *s.maxLength))
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		violations = append(violations, violation(path, "does not match %s", s.pattern.String()))
+	}
+	return violations
+}
+
+func (s *schema) validateArray(array []interface{}, path string, violations []string) []string {
+	length := float64(len(array))
+	if s.minItems != nil && length < *s.minItems {
+		violations = append(violations, violation(path, "less than %v items", *s.minItems))
+	}
+	if s.maxItems != nil && length > *s.maxItems {
+		violations = append(violations, violation(path, "more than %v items", *s.maxItems))
+	}
+	if s.items != nil {
+		for i, item := range array {
+			violations = s.items.validate(item, fmt.Sprintf("%s/%d", path, i), violations)
+		}
+	}
+	return violations
+}
+
+func (s *schema) validateObject(obj map[string]interface{}, path string, violations []string) []string {
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			violations = append(violations, violation(path, "missing property %s", name))
+		}
+	}
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := path + "/" + strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+		if sub, ok := s.properties[name]; ok {
+			violations = sub.validate(obj[name], p, violations)
+			continue
+		}
+		if s.additionalProperties != nil {
+			violations = s.additionalProperties.validate(obj[name], p, violations)
+		}
+	}
+	return violations
+}
+
+func violation(path, format string, a ...interface{}) string {
+	if path == "" {
+		path = "/"
+	}
+	return path + ": " + fmt.Sprintf(format, a...)
+}
+
+func countValid(schemas []*schema, v interface{}) int {
+	n := 0
+	for _, s := range schemas {
+		if len(s.validate(v, "", nil)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func hasSchemaType(v interface{}, types []string) bool {
+	actual := schemaType(v)
+	for _, t := range types {
+		if t == actual || t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	if f, ok := toFloat(v); ok {
+		if f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, candidate := range values {
+		if equalValues(candidate, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalValues compares numbers as numbers, whatever their go types, and the rest of values deeply
+func equalValues(a, b interface{}) bool {
+	fa, aok := toFloat(a)
+	fb, bok := toFloat(b)
+	if aok || bok {
+		return aok && bok && fa == fb
+	}
+	switch ta := a.(type) {
+	case []interface{}:
+		tb, ok := b.([]interface{})
+		if !ok || len(ta) != len(tb) {
+			return false
+		}
+		for i := range ta {
+			if !equalValues(ta[i], tb[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		tb, ok := b.(map[string]interface{})
+		if !ok || len(ta) != len(tb) {
+			return false
+		}
+		for k, v := range ta {
+			if w, ok := tb[k]; !ok || !equalValues(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const schemaTestSchema = `{
+	"type": "object",
+	"required": ["id", "title", "tags"],
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"title": {"type": "string", "minLength": 1, "maxLength": 10},
+		"price": {"type": ["number", "null"], "exclusiveMinimum": 0, "multipleOf": 0.5},
+		"status": {"enum": ["draft", "published"]},
+		"slug": {"type": "string", "pattern": "^[a-z-]+$"},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+		"author": {
+			"type": "object",
+			"properties": {"name": {"const": "Leanne"}},
+			"additionalProperties": false
+		},
+		"ref": {"oneOf": [{"type": "string"}, {"type": "integer"}]},
+		"code": {"anyOf": [{"type": "string"}, {"type": "null"}], "not": {"const": "x"}}
+	}
+}`
+
+func TestSchemaValidator_violations(t *testing.T) {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(schemaTestSchema), &raw); err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := compileSchema(raw)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, tc := range []struct {
+		data       string
+		violations []string
+	}{
+		{`{"id":1,"title":"Hello","tags":[],"price":2.5,"status":"draft","slug":"a-b","author":{"name":"Leanne"},"ref":1,"code":null}`, nil},
+		{`{"id":1,"title":"Hello","tags":[],"price":null}`, nil},
+		{`[]`, []string{"/: expected object, got array"}},
+		{`{"title":"Hello"}`, []string{"/: missing property id", "/: missing property tags"}},
+		{`{"id":0,"title":"","tags":["a",1,"b"]}`, []string{
+			"/id: 0 is lower than 1",
+			"/tags: more than 2 items",
+			"/tags/1: expected string, got integer",
+			"/title: shorter than 1 characters",
+		}},
+		{`{"id":1.5,"title":"Hello world!","tags":[]}`, []string{
+			"/id: expected integer, got number",
+			"/title: longer than 10 characters",
+		}},
+		{`{"id":1,"title":"a","tags":[],"price":0.7,"status":"deleted","slug":"A"}`, []string{
+			"/price: 0.7 is not a multiple of 0.5",
+			"/slug: does not match ^[a-z-]+$",
+			"/status: value not allowed: deleted",
+		}},
+		{`{"id":1,"title":"a","tags":[],"price":0}`, []string{"/price: 0 is not greater than 0"}},
+		{`{"id":1,"title":"a","tags":[],"author":{"name":"Ervin","email":"e"}}`, []string{
+			"/author/email: no value allowed",
+			"/author/name: expected Leanne, got Ervin",
+		}},
+		{`{"id":1,"title":"a","tags":[],"ref":true,"code":"x"}`, []string{
+			"/code: the value matches the not schema",
+			"/ref: 0 schemas of oneOf matched",
+		}},
+		{`{"id":1,"title":"a","tags":[],"code":1}`, []string{"/code: no schema of anyOf matched"}},
+	} {
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewBufferString(tc.data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		violations := s.validate(v, "", nil)
+		if fmt.Sprint(violations) != fmt.Sprint(tc.violations) {
+			t.Errorf("#%d: unexpected violations: %q", i, violations)
+		}
+	}
+}
+
+func TestNewSchemaValidator_ko(t *testing.T) {
+	for i, cfg := range []SchemaConfig{
+		{},
+		{File: "unknown.json"},
+		{Schema: "object"},
+		{Schema: map[string]interface{}{"type": "list"}},
+		{Schema: map[string]interface{}{"pattern": "("}},
+		{Schema: map[string]interface{}{"properties": map[string]interface{}{"a": 1}}},
+		{Schema: map[string]interface{}{"anyOf": []interface{}{}}},
+		{Schema: map[string]interface{}{"$ref": "#/definitions/a", "definitions": map[string]interface{}{"a": true}}},
+		{Schema: map[string]interface{}{"properties": map[string]interface{}{"a": map[string]interface{}{"uniqueItems": true}}}},
+		{Schema: map[string]interface{}{"items": map[string]interface{}{"if": true, "then": false}}},
+		{Schema: true, OnViolation: "ignore"},
+		{Schema: true, OnViolation: SchemaFallback},
+	} {
+		if _, err := NewSchemaValidator("ko", cfg); err == nil {
+			t.Errorf("#%d: error expected", i)
+		}
+	}
+}
+
+func TestNewSchemaValidator_unsupportedKeywords(t *testing.T) {
+	_, err := NewSchemaValidator("ko", SchemaConfig{Schema: map[string]interface{}{
+		"$schema":           "http://json-schema.org/draft-07/schema#",
+		"title":             "product",
+		"patternProperties": map[string]interface{}{"^x-": true},
+		"minProperties":     1,
+	}})
+	if err == nil || !strings.Contains(err.Error(), "unsupported keywords: minProperties, patternProperties") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewSchemaValidator(t *testing.T) {
+	f, err := ioutil.TempFile("", "api2html-schema")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"type": "object", "required": ["id"]}`)
+	f.Close()
+
+	for i, tc := range []struct {
+		cfg SchemaConfig
+		err error
+	}{
+		{SchemaConfig{File: f.Name()}, nil},
+		{SchemaConfig{File: f.Name(), OnViolation: SchemaFail}, &SchemaError{[]string{"/: missing property id"}}},
+		{SchemaConfig{File: f.Name(), OnViolation: SchemaFallback, Template: "fallback"}, &StatusError{StatusCode: 200, Template: "fallback"}},
+		{SchemaConfig{File: f.Name(), OnViolation: SchemaFallback, Template: "fallback", StatusCode: 503}, &StatusError{StatusCode: 503, Template: "fallback"}},
+	} {
+		name := fmt.Sprintf("schema-%d", i)
+		sv, err := NewSchemaValidator(name, tc.cfg)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if err := sv.Validate(map[string]interface{}{"id": 1}); err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+		if err := sv.Validate(map[string]interface{}{}); fmt.Sprint(err) != fmt.Sprint(tc.err) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+		state := SchemaValidators()[name]
+		if state.Validations != 2 || state.Violations != 1 || state.LastViolation != "/: missing property id" {
+			t.Errorf("#%d: unexpected state: %v", i, state)
+		}
+	}
+
+	var sv *SchemaValidator
+	if err := sv.Validate(nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewHandlerConfig_schema(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"results":[{"id":1},{"title":"Hello"}]}`)
+	}))
+	defer mockServer.Close()
+
+	schema := map[string]interface{}{
+		"type":  "array",
+		"items": map[string]interface{}{"required": []interface{}{"id"}},
+	}
+	for i, tc := range []struct {
+		cfg SchemaConfig
+		err string
+	}{
+		{SchemaConfig{Schema: schema}, ""},
+		{SchemaConfig{Schema: schema, OnViolation: SchemaFail}, "schema violations: /1: missing property id"},
+		{SchemaConfig{Schema: schema, OnViolation: SchemaFallback, Template: "fallback"}, "backend response mapped to the status code 200"},
+		{SchemaConfig{Schema: schema, OnViolation: "ignore"}, "unknown schema violation mode: ignore"},
+	} {
+		cfg := NewHandlerConfig(Page{
+			Name:              "schema",
+			BackendURLPattern: mockServer.URL,
+			Transform:         []TransformStep{{Select: "results"}},
+			Schema:            &tc.cfg,
+		})
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/", nil)
+		result, err := cfg.ResponseGenerator(c)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("#%d: unexpected error: %v", i, err)
+			}
+		} else if err == nil || err.Error() != tc.err {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if i < 3 && len(result.Array) != 2 {
+			t.Errorf("#%d: unexpected result: %v", i, result)
+		}
+	}
+
+	subscriptionChan := make(chan Subscription)
+	NewHandler(HandlerConfig{
+		Page:     Page{Template: "name", Schema: &SchemaConfig{Template: "fallback"}},
+		Renderer: EmptyRenderer,
+	}, subscriptionChan)
+	names := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		names[(<-subscriptionChan).Name] = true
+	}
+	if !names["name"] || !names["fallback"] {
+		t.Errorf("unexpected subscriptions: %v", names)
+	}
+}
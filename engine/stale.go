@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultStaleMaxEntries = 10000
+
+// NewStaleCache creates a StaleCache with the received config, identifying the backend requests
+// with the given RequestKeyFunc
+func NewStaleCache(cfg StaleConfig, key RequestKeyFunc) *StaleCache {
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultStaleMaxEntries
+	}
+	return &StaleCache{
+		ttl:                  parseDuration(cfg.TTL, 0),
+		staleWhileRevalidate: parseDuration(cfg.StaleWhileRevalidate, 0),
+		staleIfError:         parseDuration(cfg.StaleIfError, 0),
+		maxEntries:           maxEntries,
+		key:                  key,
+		entries:              map[string]staleEntry{},
+		refreshing:           map[string]struct{}{},
+		mutex:                &sync.Mutex{},
+	}
+}
+
+// StaleCache keeps the last good decoded response of every backend request, so it can be served
+// while the backend is failing or while it is refreshed in background
+type StaleCache struct {
+	ttl                  time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	maxEntries           int
+	key                  RequestKeyFunc
+	entries              map[string]staleEntry
+	refreshing           map[string]struct{}
+	mutex                *sync.Mutex
+}
+
+type staleEntry struct {
+	data     map[string]interface{}
+	array    []map[string]interface{}
+	value    interface{}
+	storedAt time.Time
+}
+
+// FetchFunc is a function decoding the response of a backend into the received ResponseContext
+type FetchFunc func(c *gin.Context, result *ResponseContext) error
+
+// Fetch decodes the backend response into the result using the received FetchFunc. Fresh responses
+// are served without calling the backend, expired ones are refreshed in background during the
+// stale-while-revalidate window and the stale ones are served if the backend fails during the
+// stale-if-error window. A nil StaleCache just calls the FetchFunc
+func (s *StaleCache) Fetch(params map[string]string, headers map[string]string, c *gin.Context, result *ResponseContext, fetch FetchFunc) error {
+	if s == nil {
+		return fetch(c, result)
+	}
+
+	key := s.key(params, headers, c)
+	s.mutex.Lock()
+	entry, ok := s.entries[key]
+	s.mutex.Unlock()
+	age := time.Since(entry.storedAt)
+
+	if ok && age < s.ttl {
+		entry.copyTo(result)
+		return nil
+	}
+	if ok && age < s.ttl+s.staleWhileRevalidate {
+		s.refresh(key, c.Copy(), fetch)
+		entry.copyTo(result)
+		return nil
+	}
+
+	tmp := ResponseContext{}
+	err := fetch(c, &tmp)
+	if err == nil {
+		s.store(key, tmp)
+	} else if ok && age < s.ttl+s.staleIfError && isBackendFailure(err) {
+		log.Println("serving a stale response:", err.Error())
+		entry.copyTo(result)
+		return nil
+	}
+	result.Data = tmp.Data
+	result.Array = tmp.Array
+	result.Value = tmp.Value
+	return err
+}
+
+func isBackendFailure(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	return !ok || statusErr.StatusCode >= 500
+}
+
+func (s *StaleCache) refresh(key string, c *gin.Context, fetch FetchFunc) {
+	s.mutex.Lock()
+	if _, ok := s.refreshing[key]; ok {
+		s.mutex.Unlock()
+		return
+	}
+	s.refreshing[key] = struct{}{}
+	s.mutex.Unlock()
+
+	go func() {
+		tmp := ResponseContext{}
+		if err := fetch(c, &tmp); err == nil {
+			s.store(key, tmp)
+		} else {
+			log.Println("refreshing a stale response:", err.Error())
+		}
+		s.mutex.Lock()
+		delete(s.refreshing, key)
+		s.mutex.Unlock()
+	}()
+}
+
+func (s *StaleCache) store(key string, r ResponseContext) {
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.entries[key]; !ok && len(s.entries) >= s.maxEntries {
+		maxAge := s.ttl + s.staleWhileRevalidate
+		if s.staleIfError > s.staleWhileRevalidate {
+			maxAge = s.ttl + s.staleIfError
+		}
+		for k, e := range s.entries {
+			if now.Sub(e.storedAt) >= maxAge {
+				delete(s.entries, k)
+			}
+		}
+		for k := range s.entries {
+			if len(s.entries) < s.maxEntries {
+				break
+			}
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = staleEntry{r.Data, r.Array, r.Value, now}
+}
+
+func (e staleEntry) copyTo(r *ResponseContext) {
+	r.Data = e.data
+	r.Array = e.array
+	r.Value = e.value
+}
@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStaleCache_nil(t *testing.T) {
+	var subject *StaleCache
+	calls := 0
+	r := ResponseContext{}
+	err := subject.Fetch(params, headers, nil, &r, func(_ *gin.Context, r *ResponseContext) error {
+		calls++
+		r.Data = map[string]interface{}{"a": 1}
+		return nil
+	})
+	if err != nil {
+		t.Error("unexpected error:", err.Error())
+	}
+	if calls != 1 || r.Data["a"] != 1 {
+		t.Errorf("unexpected result. calls: %d, data: %v", calls, r.Data)
+	}
+}
+
+func TestStaleCache_staleWhileRevalidate(t *testing.T) {
+	var calls int32
+	fetch := func(_ *gin.Context, r *ResponseContext) error {
+		r.Data = map[string]interface{}{"call": atomic.AddInt32(&calls, 1)}
+		return nil
+	}
+	subject := NewStaleCache(StaleConfig{TTL: "20ms", StaleWhileRevalidate: "1h"}, constantKey)
+	c := newTestContext()
+
+	for i, expected := range []int32{1, 1} {
+		r := ResponseContext{}
+		if err := subject.Fetch(params, headers, c, &r, fetch); err != nil {
+			t.Errorf("#%d: unexpected error: %s", i, err.Error())
+		}
+		if r.Data["call"] != expected {
+			t.Errorf("#%d: unexpected data: %v", i, r.Data)
+		}
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	r := ResponseContext{}
+	if err := subject.Fetch(params, headers, c, &r, fetch); err != nil {
+		t.Error("unexpected error:", err.Error())
+	}
+	if r.Data["call"] != int32(1) {
+		t.Errorf("the stale response should be served. have: %v", r.Data)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	r = ResponseContext{}
+	if err := subject.Fetch(params, headers, c, &r, fetch); err != nil {
+		t.Error("unexpected error:", err.Error())
+	}
+	if r.Data["call"] != int32(2) {
+		t.Errorf("the refreshed response should be served. have: %v", r.Data)
+	}
+}
+
+func TestStaleCache_staleIfError(t *testing.T) {
+	backendErr := fmt.Errorf("backendErr")
+	var fetchErr error
+	fetch := func(_ *gin.Context, r *ResponseContext) error {
+		if fetchErr != nil {
+			return fetchErr
+		}
+		r.Data = map[string]interface{}{"a": true}
+		return nil
+	}
+	subject := NewStaleCache(StaleConfig{StaleIfError: "20ms"}, constantKey)
+	c := newTestContext()
+
+	r := ResponseContext{}
+	if err := subject.Fetch(params, headers, c, &r, fetch); err != nil {
+		t.Error("unexpected error:", err.Error())
+	}
+
+	for _, fetchErr = range []error{backendErr, &StatusError{StatusCode: 500}} {
+		r = ResponseContext{}
+		if err := subject.Fetch(params, headers, c, &r, fetch); err != nil {
+			t.Error("unexpected error:", err.Error())
+		}
+		if r.Data["a"] != true {
+			t.Errorf("the stale response should be served. have: %v", r.Data)
+		}
+	}
+
+	fetchErr = &StatusError{StatusCode: 404}
+	r = ResponseContext{}
+	if err := subject.Fetch(params, headers, c, &r, fetch); err != fetchErr {
+		t.Error("unexpected error:", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	fetchErr = backendErr
+	r = ResponseContext{}
+	if err := subject.Fetch(params, headers, c, &r, fetch); err != backendErr {
+		t.Error("unexpected error:", err)
+	}
+	if r.Data != nil {
+		t.Errorf("unexpected data: %v", r.Data)
+	}
+}
+
+func TestStaleCache_maxEntries(t *testing.T) {
+	subject := NewStaleCache(StaleConfig{TTL: "1h", MaxEntries: 2}, func(params map[string]string, _ map[string]string, _ *gin.Context) string {
+		return params["param"]
+	})
+	c := newTestContext()
+	for _, p := range []string{"a", "b", "c"} {
+		r := ResponseContext{}
+		subject.Fetch(map[string]string{"param": p}, headers, c, &r, func(_ *gin.Context, r *ResponseContext) error {
+			r.Data = map[string]interface{}{}
+			return nil
+		})
+	}
+	if len(subject.entries) != 2 {
+		t.Errorf("unexpected number of entries: %d", len(subject.entries))
+	}
+}
+
+func constantKey(_ map[string]string, _ map[string]string, _ *gin.Context) string {
+	return "key"
+}
+
+func newTestContext() *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+	return c
+}
+
+func TestStaleCache_value(t *testing.T) {
+	calls := 0
+	fetch := func(_ *gin.Context, r *ResponseContext) error {
+		calls++
+		r.Value = []interface{}{"a", "b"}
+		return nil
+	}
+	subject := NewStaleCache(StaleConfig{TTL: "1h"}, constantKey)
+	c := newTestContext()
+
+	for i := 0; i < 2; i++ {
+		r := ResponseContext{}
+		if err := subject.Fetch(params, headers, c, &r, fetch); err != nil {
+			t.Errorf("#%d: unexpected error: %s", i, err.Error())
+		}
+		if v, ok := r.Value.([]interface{}); !ok || len(v) != 2 {
+			t.Errorf("#%d: unexpected value: %v", i, r.Value)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The names of the built-in template engines
+const (
+	// MustacheEngine parses the templates as mustache templates
+	MustacheEngine = "mustache"
+	// HTMLTemplateEngine parses the templates with the html/template package
+	HTMLTemplateEngine = "html"
+)
+
+// DefaultTemplateEngine is the engine of the templates without an explicit engine or a registered
+// extension
+var DefaultTemplateEngine = MustacheEngine
+
+// TemplateEngine parses a template, resolving the partials it includes with the received
+// PartialProvider, and returns its Renderer
+type TemplateEngine func(r io.Reader, partials PartialProvider) (Renderer, error)
+
+// PartialProvider returns the source of a partial by name. Unknown partials are empty
+type PartialProvider interface {
+	Get(name string) (string, error)
+}
+
+// PartialDependent is implemented by the renderers including partials, so they can be parsed again
+// when any of them changes
+type PartialDependent interface {
+	Renderer
+	// Partials returns the names of all the partials included by the template, directly or not
+	Partials() []string
+}
+
+// LayoutComposer is implemented by the renderers able to render their content inside a layout
+// parsed by the same engine
+type LayoutComposer interface {
+	Renderer
+	// WithLayout returns a Renderer composing the template with the received layout
+	WithLayout(layout Renderer) (Renderer, error)
+}
+
+var (
+	templateEngines    = &sync.Map{}
+	templateExtensions = &sync.Map{}
+)
+
+func init() {
+	RegisterTemplateEngine(MustacheEngine, func(r io.Reader, partials PartialProvider) (Renderer, error) {
+		return NewMustacheRendererWithPartials(r, partials)
+	}, ".mustache", ".mst")
+	RegisterTemplateEngine(HTMLTemplateEngine, func(r io.Reader, partials PartialProvider) (Renderer, error) {
+		return NewHTMLTemplateRendererWithPartials(r, partials)
+	}, ".tmpl", ".gohtml")
+}
+
+// RegisterTemplateEngine registers a TemplateEngine with the received name, so templates can select
+// it by name. The templates with any of the received file extensions (ex: ".tmpl") are parsed with
+// it by default
+func RegisterTemplateEngine(name string, e TemplateEngine, extensions ...string) {
+	templateEngines.Store(name, e)
+	for _, ext := range extensions {
+		templateExtensions.Store(strings.ToLower(ext), name)
+	}
+}
+
+// TemplateEngineName returns the name of the engine for the template at the received path. The
+// received engine name takes precedence over the file extension
+func TemplateEngineName(engine, path string) string {
+	if engine != "" {
+		return engine
+	}
+	if name, ok := templateExtensions.Load(strings.ToLower(filepath.Ext(path))); ok {
+		return name.(string)
+	}
+	return DefaultTemplateEngine
+}
+
+// NewTemplateRenderer parses the template with the engine selected by name or, if empty, by the
+// extension of the received path
+func NewTemplateRenderer(engine, path string, r io.Reader) (Renderer, error) {
+	return parseTemplate(engine, path, r, customPartialProvider)
+}
+
+func parseTemplate(engine, path string, r io.Reader, partials PartialProvider) (Renderer, error) {
+	name := TemplateEngineName(engine, path)
+	e, ok := templateEngines.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown template engine: %s", name)
+	}
+	return e.(TemplateEngine)(r, partials)
+}
+
+// partialNames returns the sorted names of the collected partials
+func partialNames(names map[string]bool) []string {
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// NewLayoutRenderer composes the received template with the received layout. Both of them must
+// have been parsed by the same engine
+func NewLayoutRenderer(tmpl, layout Renderer) (Renderer, error) {
+	c, ok := tmpl.(LayoutComposer)
+	if !ok {
+		return nil, fmt.Errorf("the template engine does not support layouts")
+	}
+	return c.WithLayout(layout)
+}
+
+// NewRendererMap returns a map with the renderers for all the declared templates and layouts,
+// parsed with the engine defined in the Engines section of the config or, if not defined, with
+// the engine registered for their file extension
+func NewRendererMap(cfg Config) (map[string]Renderer, error) {
+	result := map[string]Renderer{}
+	for _, section := range []map[string]string{cfg.Templates, cfg.Layouts} {
+		for name, path := range section {
+			templateFile, err := os.Open(path)
+			if err != nil {
+				log.Println("reading", path, ":", err.Error())
+				return result, err
+			}
+			renderer, err := NewTemplateRenderer(cfg.Engines[name], path, templateFile)
+			templateFile.Close()
+			if err != nil {
+				log.Println("parsing", path, ":", err.Error())
+				return result, err
+			}
+			result[name] = renderer
+		}
+	}
+	return result, nil
+}
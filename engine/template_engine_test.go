@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplateEngineName(t *testing.T) {
+	RegisterTemplateEngine("custom", func(_ io.Reader, _ PartialProvider) (Renderer, error) { return EmptyRenderer, nil }, ".Custom")
+	for i, tc := range []struct {
+		engine   string
+		path     string
+		expected string
+	}{
+		{"", "tmpl/home.mustache", MustacheEngine},
+		{"", "tmpl/home.html", MustacheEngine},
+		{"", "tmpl/home.tmpl", HTMLTemplateEngine},
+		{"", "tmpl/home.GOHTML", HTMLTemplateEngine},
+		{"", "tmpl/home.custom", "custom"},
+		{HTMLTemplateEngine, "tmpl/home.mustache", HTMLTemplateEngine},
+	} {
+		if name := TemplateEngineName(tc.engine, tc.path); name != tc.expected {
+			t.Errorf("#%d: unexpected engine: %s", i, name)
+		}
+	}
+
+	if _, err := NewTemplateRenderer("unknown", "home.tmpl", bytes.NewBufferString("")); err == nil {
+		t.Error("expecting error")
+	}
+}
+
+func TestNewRendererMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api2html-templates")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"layout.mustache": `-{{{ content }}}-`,
+		"home.mustache":   `{{ a }}`,
+		"layout.tmpl":     `-{{template "content" .}}-`,
+		"product.tmpl":    `{{ .a }}`,
+		"legacy.html":     `{{ .a }}`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	renderers, err := NewRendererMap(Config{
+		Templates: map[string]string{
+			"home":    filepath.Join(dir, "home.mustache"),
+			"product": filepath.Join(dir, "product.tmpl"),
+			"legacy":  filepath.Join(dir, "legacy.html"),
+		},
+		Layouts: map[string]string{
+			"mustache": filepath.Join(dir, "layout.mustache"),
+			"html":     filepath.Join(dir, "layout.tmpl"),
+		},
+		Engines: map[string]string{"legacy": HTMLTemplateEngine},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, tc := range []struct {
+		template string
+		layout   string
+		ok       bool
+	}{
+		{"home", "mustache", true},
+		{"product", "html", true},
+		{"legacy", "html", true},
+		{"home", "html", false},
+		{"product", "mustache", false},
+	} {
+		r, err := NewLayoutRenderer(renderers[tc.template], renderers[tc.layout])
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("#%d: expecting error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if err := checkRenderer(r); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+
+	if _, err := NewLayoutRenderer(EmptyRenderer, renderers["html"]); err == nil {
+		t.Error("expecting error")
+	}
+	if _, err := NewRendererMap(Config{Templates: map[string]string{"home": filepath.Join(dir, "home.mustache")}, Engines: map[string]string{"home": "unknown"}}); err == nil {
+		t.Error("expecting error")
+	}
+}
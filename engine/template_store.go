@@ -1,6 +1,11 @@
 package engine
 
-import "sync"
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync"
+)
 
 // NewTemplateStore creates a TemplateStore ready to be used
 //
@@ -14,6 +19,13 @@ func NewTemplateStore() *TemplateStore {
 		},
 		make(chan Subscription),
 		&sync.Map{},
+		&templateSources{
+			&sync.Mutex{},
+			map[string]templateSource{},
+			map[string]string{},
+			map[string]composition{},
+			map[string]string{},
+		},
 	}
 	go store.subscribe()
 	return store
@@ -24,6 +36,7 @@ type TemplateStore struct {
 	*templateStore
 	Subscribe chan Subscription
 	observers *sync.Map
+	sources   *templateSources
 }
 
 func (p *TemplateStore) subscribe() {
@@ -56,6 +69,262 @@ func (p *TemplateStore) Set(name string, tmpl Renderer) error {
 	return nil
 }
 
+// Partials returns a PartialProvider resolving the partials stored in the TemplateStore. The rest of
+// them are resolved as files relative to the working directory
+func (p *TemplateStore) Partials() PartialProvider {
+	return storePartialProvider{p.sources}
+}
+
+// SetPartial adds or updates the partial with the given name. All the stored templates including
+// it are parsed again and the subscriptors of them and of their compositions with layouts receive
+// the new renderers. If any of those templates can not be parsed with the new partial, the previous
+// one is kept and nothing is updated
+func (p *TemplateStore) SetPartial(name, src string) error {
+	p.sources.mutex.Lock()
+	dependents := map[string]templateSource{}
+	for template, source := range p.sources.templates {
+		for _, partial := range source.partials {
+			if partial == name {
+				dependents[template] = source
+				break
+			}
+		}
+	}
+	p.sources.mutex.Unlock()
+
+	partials := candidatePartialProvider{p.Partials(), name, src}
+	renderers := make(map[string]Renderer, len(dependents))
+	for template, source := range dependents {
+		r, parsed, err := parseSource(source.engine, source.path, source.src, partials)
+		if err != nil {
+			log.Println("parsing", template, "with the new version of the partial", name, ":", err.Error())
+			return err
+		}
+		renderers[template] = r
+		dependents[template] = parsed
+	}
+
+	p.sources.mutex.Lock()
+	p.sources.partials[name] = src
+	for template, source := range dependents {
+		p.sources.templates[template] = source
+	}
+	p.sources.mutex.Unlock()
+
+	var result error
+	for template, r := range renderers {
+		if err := p.publish(template, r); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// Parse parses the template with the engine selected by name or, if empty, by the extension of the
+// received path and stores it with the given name, without alerting the subscriptors. The
+// partials are resolved with the ones of the store, so the template is parsed again every time
+// one of them changes
+func (p *TemplateStore) Parse(name, engine, path string, src []byte) (Renderer, error) {
+	r, source, err := parseSource(engine, path, src, p.Partials())
+	if err != nil {
+		return nil, err
+	}
+	p.sources.mutex.Lock()
+	p.sources.templates[name] = source
+	p.sources.mutex.Unlock()
+	return r, p.templateStore.Set(name, r)
+}
+
+// Update parses and stores the template like Parse does and alerts the subscriptors of it and of
+// its compositions with layouts
+func (p *TemplateStore) Update(name, engine, path string, src []byte) error {
+	r, err := p.Parse(name, engine, path, src)
+	if err != nil {
+		return err
+	}
+	return p.publish(name, r)
+}
+
+// Compose composes the stored template with the stored layout, and the layouts it extends, and sets
+// the result under the topic of the pair. The composition is done again every time the template or
+// any of the layouts changes
+func (p *TemplateStore) Compose(template, layout string) error {
+	topic := rendererTopic(layout, template)
+	p.sources.mutex.Lock()
+	p.sources.compositions[topic] = composition{template, layout}
+	p.sources.mutex.Unlock()
+	return p.compose(topic, template, layout)
+}
+
+// Extend makes the layout extend the parent one, so the layout is rendered inside the parent
+// layout and can fill its named blocks. The compositions using the layout are done again
+func (p *TemplateStore) Extend(layout, parent string) error {
+	p.sources.mutex.Lock()
+	p.sources.parents[layout] = parent
+	if len(p.sources.chain(layout)) == 0 {
+		delete(p.sources.parents, layout)
+		p.sources.mutex.Unlock()
+		return fmt.Errorf("circular layout extension: %s extends %s", layout, parent)
+	}
+	p.sources.mutex.Unlock()
+	return p.recompose(layout)
+}
+
+func (p *TemplateStore) compose(topic, template, layout string) error {
+	r, ok := p.Get(template)
+	if !ok {
+		return fmt.Errorf("template not defined: %s", template)
+	}
+	l, err := p.layout(layout)
+	if err != nil {
+		return err
+	}
+	lr, err := NewLayoutRenderer(r, l)
+	if err != nil {
+		return err
+	}
+	return p.Set(topic, lr)
+}
+
+// layout returns the stored layout composed with all the layouts it extends
+func (p *TemplateStore) layout(name string) (Renderer, error) {
+	chain := p.sources.layoutChain(name)
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("circular layout extension: %s", name)
+	}
+	l, ok := p.Get(chain[len(chain)-1])
+	if !ok {
+		return nil, fmt.Errorf("layout not defined: %s", chain[len(chain)-1])
+	}
+	for i := len(chain) - 2; i >= 0; i-- {
+		child, ok := p.Get(chain[i])
+		if !ok {
+			return nil, fmt.Errorf("layout not defined: %s", chain[i])
+		}
+		var err error
+		if l, err = NewLayoutRenderer(child, l); err != nil {
+			return nil, fmt.Errorf("extending the layout %s with %s: %s", chain[i+1], chain[i], err.Error())
+		}
+	}
+	return l, nil
+}
+
+// publish sets the renderer with the given name and composes again all the compositions using it
+func (p *TemplateStore) publish(name string, r Renderer) error {
+	if err := p.Set(name, r); err != nil {
+		return err
+	}
+	return p.recompose(name)
+}
+
+// recompose composes again all the compositions using the template or layout with the given name
+func (p *TemplateStore) recompose(name string) error {
+	p.sources.mutex.Lock()
+	compositions := map[string]composition{}
+	for topic, c := range p.sources.compositions {
+		if c.template == name {
+			compositions[topic] = c
+			continue
+		}
+		for _, layout := range p.sources.chain(c.layout) {
+			if layout == name {
+				compositions[topic] = c
+				break
+			}
+		}
+	}
+	p.sources.mutex.Unlock()
+
+	for topic, c := range compositions {
+		if err := p.compose(topic, c.template, c.layout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type templateSources struct {
+	mutex        *sync.Mutex
+	templates    map[string]templateSource
+	partials     map[string]string
+	compositions map[string]composition
+	parents      map[string]string
+}
+
+// layoutChain returns the layout followed by all the layouts it extends, or an empty chain if the
+// extensions are circular
+func (s *templateSources) layoutChain(layout string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.chain(layout)
+}
+
+func (s *templateSources) chain(layout string) []string {
+	chain := []string{layout}
+	for parent, ok := s.parents[layout]; ok; parent, ok = s.parents[parent] {
+		for _, name := range chain {
+			if name == parent {
+				return []string{}
+			}
+		}
+		chain = append(chain, parent)
+	}
+	return chain
+}
+
+// parseSource parses the template and returns it along with its source and the partials it includes
+func parseSource(engine, path string, src []byte, partials PartialProvider) (Renderer, templateSource, error) {
+	source := templateSource{engine: engine, path: path, src: src}
+	r, err := parseTemplate(engine, path, bytes.NewReader(src), partials)
+	if err != nil {
+		return nil, source, err
+	}
+	if d, ok := r.(PartialDependent); ok {
+		source.partials = d.Partials()
+	}
+	return r, source, nil
+}
+
+type templateSource struct {
+	engine   string
+	path     string
+	src      []byte
+	partials []string
+}
+
+type composition struct {
+	template string
+	layout   string
+}
+
+type storePartialProvider struct {
+	sources *templateSources
+}
+
+func (s storePartialProvider) Get(name string) (string, error) {
+	s.sources.mutex.Lock()
+	src, ok := s.sources.partials[name]
+	s.sources.mutex.Unlock()
+	if ok {
+		return src, nil
+	}
+	return customPartialProvider.Get(name)
+}
+
+// candidatePartialProvider resolves the partial with the given name with a source not stored yet
+type candidatePartialProvider struct {
+	PartialProvider
+	name string
+	src  string
+}
+
+func (c candidatePartialProvider) Get(name string) (string, error) {
+	if name == c.name {
+		return c.src, nil
+	}
+	return c.PartialProvider.Get(name)
+}
+
 type templateStore struct {
 	data  map[string]Renderer
 	mutex map[string]*sync.RWMutex
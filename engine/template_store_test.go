@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTemplateStore_SetPartial(t *testing.T) {
+	ts := NewTemplateStore()
+	if err := ts.SetPartial("header", "<h1>{{title}}</h1>"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := ts.SetPartial("title", "{{title}}"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := ts.SetPartial("html/header", "<h1>{{.title}}</h1>"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for name, src := range map[string]string{
+		"home":   "{{> header}}<p>home</p>",
+		"about":  "<p>about</p>",
+		"layout": "<title>{{> title}}</title>{{{content}}}",
+	} {
+		if _, err := ts.Parse(name, "", name+".mustache", []byte(src)); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if _, err := ts.Parse("legacy", HTMLTemplateEngine, "", []byte(`{{template "html/header" .}}<p>legacy</p>`)); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := ts.Compose("home", "layout"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	topics := []string{"home", "about", "legacy", "layout", rendererTopic("layout", "home")}
+	subscriptions := map[string]chan Renderer{}
+	for _, topic := range topics {
+		subscriptions[topic] = make(chan Renderer, 1)
+		ts.Subscribe <- Subscription{topic, subscriptions[topic]}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if err := ts.SetPartial("header", "<header>{{title}}</header>"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := ts.SetPartial("title", "[{{title}}]"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := ts.SetPartial("html/header", "<header>{{.title}}</header>"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for topic, expected := range map[string]string{
+		"home":                          "<header>a</header><p>home</p>",
+		rendererTopic("layout", "home"): "<title>[a]</title><header>a</header><p>home</p>",
+		"layout":                        "<title>[a]</title>",
+		"legacy":                        "<header>a</header><p>legacy</p>",
+	} {
+		select {
+		case r := <-subscriptions[topic]:
+			w := &bytes.Buffer{}
+			if err := r.Render(w, map[string]interface{}{"title": "a"}); err != nil {
+				t.Errorf("%s: %s", topic, err.Error())
+				continue
+			}
+			if w.String() != expected {
+				t.Errorf("%s: unexpected result: %s", topic, w.String())
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: the renderer was not updated", topic)
+		}
+	}
+
+	select {
+	case <-subscriptions["about"]:
+		t.Error("the template without partials was updated")
+	default:
+	}
+}
+
+func TestTemplateStore_SetPartial_nested(t *testing.T) {
+	ts := NewTemplateStore()
+	ts.SetPartial("header", "<h1>{{> title}}</h1>")
+	ts.SetPartial("title", "{{title}}")
+
+	r, err := ts.Parse("home", "", "", []byte("{{#title}}{{> header}}{{/title}}"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	d, ok := r.(PartialDependent)
+	if !ok {
+		t.Error("the renderer does not report its partials")
+		return
+	}
+	if partials := d.Partials(); len(partials) != 2 || partials[0] != "header" || partials[1] != "title" {
+		t.Errorf("unexpected partials: %v", partials)
+	}
+
+	in := make(chan Renderer, 1)
+	ts.Subscribe <- Subscription{"home", in}
+	time.Sleep(100 * time.Millisecond)
+
+	ts.SetPartial("title", "<em>{{title}}</em>")
+
+	select {
+	case r := <-in:
+		w := &bytes.Buffer{}
+		if err := r.Render(w, map[string]interface{}{"title": "a"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if w.String() != "<h1><em>a</em></h1>" {
+			t.Errorf("unexpected result: %s", w.String())
+		}
+	case <-time.After(time.Second):
+		t.Error("the renderer was not updated")
+	}
+}
+
+func TestTemplateStore_SetPartial_ko(t *testing.T) {
+	ts := NewTemplateStore()
+	ts.SetPartial("header", "<h1>{{.title}}</h1>")
+	if _, err := ts.Parse("home", HTMLTemplateEngine, "", []byte(`{{template "header" .}}`)); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := ts.SetPartial("header", "<h1>{{.title</h1>"); err == nil {
+		t.Error("expecting error")
+	}
+	if src, err := ts.Partials().Get("header"); err != nil || src != "<h1>{{.title}}</h1>" {
+		t.Errorf("the previous partial was not kept: %s, %v", src, err)
+	}
+	r, ok := ts.Get("home")
+	if !ok {
+		t.Error("template not found")
+		return
+	}
+	w := &bytes.Buffer{}
+	if err := r.Render(w, map[string]interface{}{"title": "a"}); err != nil || w.String() != "<h1>a</h1>" {
+		t.Errorf("unexpected render result: %s, %v", w.String(), err)
+	}
+	if err := ts.Update("home", "unknown", "", []byte("")); err == nil {
+		t.Error("expecting error")
+	}
+	if err := ts.Compose("home", "unknown"); err == nil {
+		t.Error("expecting error")
+	}
+}
+
+func TestTemplateStore_Extend(t *testing.T) {
+	ts := NewTemplateStore()
+	for name, src := range map[string]string{
+		"base":    "<title>{{$title}}base{{/title}}</title>{{{content}}}",
+		"section": "{{$title}}section{{/title}}<main>{{{content}}}</main>",
+		"home":    "{{a}}",
+	} {
+		if _, err := ts.Parse(name, "", "", []byte(src)); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := ts.Extend("section", "base"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := ts.Compose("home", "section"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	topic := rendererTopic("section", "home")
+	r, ok := ts.Get(topic)
+	if !ok {
+		t.Error("composition not stored")
+		return
+	}
+	w := &bytes.Buffer{}
+	if err := r.Render(w, map[string]interface{}{"a": 42}); err != nil {
+		t.Error(err)
+		return
+	}
+	if w.String() != "<title>section</title><main>42</main>" {
+		t.Errorf("unexpected result: %s", w.String())
+	}
+
+	in := make(chan Renderer, 1)
+	ts.Subscribe <- Subscription{topic, in}
+	time.Sleep(100 * time.Millisecond)
+
+	if err := ts.Update("base", "", "", []byte("<h1>{{$title}}base{{/title}}</h1>{{{content}}}")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	select {
+	case r := <-in:
+		w := &bytes.Buffer{}
+		if err := r.Render(w, map[string]interface{}{"a": 42}); err != nil {
+			t.Error(err)
+			return
+		}
+		if w.String() != "<h1>section</h1><main>42</main>" {
+			t.Errorf("unexpected result: %s", w.String())
+		}
+	case <-time.After(time.Second):
+		t.Error("the composition was not updated")
+	}
+
+	if err := ts.Extend("base", "section"); err == nil {
+		t.Error("expecting error")
+	}
+	if err := ts.Extend("section", "unknown"); err == nil {
+		t.Error("expecting error")
+	}
+}
@@ -64,6 +64,33 @@ var (
             <p>This page didn't set any parameters in the URL.</p>
         {{ /Params }}
     </div>
+    <h2>Query string parameters (<tt>Query</tt>)</h2>
+    <div class="response">
+        {{ #Query }}
+        <pre>{{ . }}</pre>
+        {{ /Query }}
+        {{ ^Query }}
+            <p>This request didn't set any query string parameters.</p>
+        {{ /Query }}
+    </div>
+    <h2>Request headers (<tt>Headers</tt>)</h2>
+    <div class="response">
+        {{ #Headers }}
+        <pre>{{ . }}</pre>
+        {{ /Headers }}
+        {{ ^Headers }}
+            <p>This page does not expose any request header.</p>
+        {{ /Headers }}
+    </div>
+    <h2>Request cookies (<tt>Cookies</tt>)</h2>
+    <div class="response">
+        {{ #Cookies }}
+        <pre>{{ . }}</pre>
+        {{ /Cookies }}
+        {{ ^Cookies }}
+            <p>This request didn't send any cookie.</p>
+        {{ /Cookies }}
+    </div>
     <h2>Extra data from config (<tt>Extra</tt>)</h2>
     <div class="response">
         {{ #Extra }}
@@ -88,8 +115,24 @@ var (
         <pre>{{ . }}</pre>
         {{ /Array }}
         {{ ^Array }}
-            <p>The backend response did not return an array or configuration does not set <tt>isArray</tt>.</p>
+            <p>The backend response did not return an array of objects.</p>
         {{ /Array }}
+
+        <h3>Response of any kind (<tt>Value</tt>)</h3>
+        {{ #Value }}
+        <pre>{{ . }}</pre>
+        {{ /Value }}
+        {{ ^Value }}
+            <p>The backend response was empty.</p>
+        {{ /Value }}
+
+        <h3>Responses from the named backends (<tt>Backends</tt>)</h3>
+        {{ #Backends }}
+        <pre>{{ . }}</pre>
+        {{ /Backends }}
+        {{ ^Backends }}
+            <p>The configuration does not declare any named backend.</p>
+        {{ /Backends }}
     </div>
 </div>
 <style type="text/css">
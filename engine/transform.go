@@ -0,0 +1,285 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Transformer reshapes a decoded backend response
+type Transformer func(interface{}) interface{}
+
+// NewTransformer creates a Transformer applying the received steps in order
+func NewTransformer(steps []TransformStep) (Transformer, error) {
+	fs := make([]Transformer, len(steps))
+	for i, step := range steps {
+		f, err := newTransformStep(step)
+		if err != nil {
+			return nil, fmt.Errorf("transform step #%d: %s", i, err.Error())
+		}
+		fs[i] = f
+	}
+	return func(v interface{}) interface{} {
+		for _, f := range fs {
+			v = f(v)
+		}
+		return v
+	}, nil
+}
+
+// TransformDecoder decorates the received Decoder, reshaping the decoded responses with the given
+// Transformer. The transformed value is stored as the decoders do, depending on its shape
+func TransformDecoder(d Decoder, t Transformer) Decoder {
+	return func(r io.Reader, c *ResponseContext) error {
+		tmp := ResponseContext{}
+		if err := d(r, &tmp); err != nil {
+			return err
+		}
+		setResponseValue(c, t(decodedValue(tmp)))
+		return nil
+	}
+}
+
+func decodedValue(c ResponseContext) interface{} {
+	switch {
+	case c.Value != nil:
+		return c.Value
+	case c.Data != nil:
+		return c.Data
+	case c.Array != nil:
+		array := make([]interface{}, len(c.Array))
+		for i, item := range c.Array {
+			array[i] = item
+		}
+		return array
+	}
+	return nil
+}
+
+func newTransformStep(step TransformStep) (Transformer, error) {
+	var fs []Transformer
+	if step.Select != "" {
+		path := splitPath(step.Select)
+		fs = append(fs, func(v interface{}) interface{} { return lookupPath(v, path) })
+	}
+	if len(step.Rename) > 0 {
+		fs = append(fs, eachObject(func(obj map[string]interface{}) map[string]interface{} {
+			result := make(map[string]interface{}, len(obj))
+			for k, v := range obj {
+				if name, ok := step.Rename[k]; ok {
+					k = name
+				}
+				result[k] = v
+			}
+			return result
+		}))
+	}
+	if len(step.Pick) > 0 {
+		paths := make([][]string, len(step.Pick))
+		for i, p := range step.Pick {
+			if paths[i] = splitPath(p); len(paths[i]) == 0 {
+				return nil, fmt.Errorf("empty pick path")
+			}
+		}
+		fs = append(fs, eachObject(func(obj map[string]interface{}) map[string]interface{} {
+			result := make(map[string]interface{}, len(paths))
+			for _, path := range paths {
+				if v := lookupPath(obj, path); v != nil {
+					setPath(result, path, v)
+				}
+			}
+			return result
+		}))
+	}
+	if step.Flatten != "" {
+		path := splitPath(step.Flatten)
+		if len(path) == 0 {
+			return nil, fmt.Errorf("empty flatten path")
+		}
+		prefix := strings.Join(path, "_") + "_"
+		fs = append(fs, eachObject(func(obj map[string]interface{}) map[string]interface{} {
+			nested, ok := lookupPath(obj, path).(map[string]interface{})
+			if !ok {
+				return obj
+			}
+			result := make(map[string]interface{}, len(obj)+len(nested))
+			for k, v := range obj {
+				if k != path[0] || len(path) > 1 {
+					result[k] = v
+				}
+			}
+			for k, v := range nested {
+				result[prefix+k] = v
+			}
+			return result
+		}))
+	}
+	if step.Sort != "" {
+		path := splitPath(step.Sort)
+		fs = append(fs, eachArray(func(array []interface{}) []interface{} {
+			result := append([]interface{}{}, array...)
+			sort.SliceStable(result, func(i, j int) bool {
+				c := compareValues(lookupPath(result[i], path), lookupPath(result[j], path))
+				if step.Desc {
+					return c > 0
+				}
+				return c < 0
+			})
+			return result
+		}))
+	}
+	if step.Limit < 0 || step.Offset < 0 {
+		return nil, fmt.Errorf("negative limit or offset")
+	}
+	if step.Limit > 0 || step.Offset > 0 {
+		fs = append(fs, eachArray(func(array []interface{}) []interface{} {
+			if step.Offset >= len(array) {
+				return []interface{}{}
+			}
+			array = array[step.Offset:]
+			if step.Limit > 0 && step.Limit < len(array) {
+				array = array[:step.Limit]
+			}
+			return array
+		}))
+	}
+
+	switch len(fs) {
+	case 0:
+		return nil, fmt.Errorf("no operation defined")
+	case 1:
+		return fs[0], nil
+	}
+	return nil, fmt.Errorf("more than one operation defined")
+}
+
+// eachObject returns a Transformer applying the received function to the objects or, if the value
+// is an array, to all the objects in the array
+func eachObject(f func(map[string]interface{}) map[string]interface{}) Transformer {
+	return func(v interface{}) interface{} {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			return f(t)
+		case []interface{}:
+			result := make([]interface{}, len(t))
+			for i, item := range t {
+				if obj, ok := item.(map[string]interface{}); ok {
+					result[i] = f(obj)
+					continue
+				}
+				result[i] = item
+			}
+			return result
+		}
+		return v
+	}
+}
+
+// eachArray returns a Transformer applying the received function to the arrays
+func eachArray(f func([]interface{}) []interface{}) Transformer {
+	return func(v interface{}) interface{} {
+		if array, ok := v.([]interface{}); ok {
+			return f(array)
+		}
+		return v
+	}
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, ".")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// lookupPath returns the value at the received path. Numeric keys select an element of an array
+// (negative ones count from the end) and other keys applied to an array are applied to all its
+// elements, collecting the non nil results
+func lookupPath(v interface{}, path []string) interface{} {
+	for i, k := range path {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v = t[k]
+		case []map[string]interface{}:
+			v = decodedValue(ResponseContext{Array: t})
+			return lookupPath(v, path[i:])
+		case []interface{}:
+			if idx, err := strconv.Atoi(k); err == nil {
+				if idx < 0 {
+					idx += len(t)
+				}
+				if idx < 0 || idx >= len(t) {
+					return nil
+				}
+				v = t[idx]
+				continue
+			}
+			result := []interface{}{}
+			for _, item := range t {
+				if r := lookupPath(item, path[i:]); r != nil {
+					result = append(result, r)
+				}
+			}
+			return result
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func setPath(obj map[string]interface{}, path []string, v interface{}) {
+	for _, k := range path[:len(path)-1] {
+		next, ok := obj[k].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			obj[k] = next
+		}
+		obj = next
+	}
+	obj[path[len(path)-1]] = v
+}
+
+// compareValues compares numbers as numbers and the rest of values by their string representation.
+// Missing values go first
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	fa, aok := toFloat(a)
+	fb, bok := toFloat(b)
+	if aok && bok {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	case float64:
+		return t, true
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	}
+	return 0, false
+}
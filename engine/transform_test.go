@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const transformTestPayload = `{
+	"meta": {"total": 4},
+	"results": [
+		{"id": 1, "title": "Mouse", "price": 20, "seller": {"name": "Acme", "city": "Madrid"}},
+		{"id": 2, "title": "Keyboard", "price": 45.5, "seller": {"name": "Globex", "city": "Bilbao"}},
+		{"id": 3, "title": "Monitor", "price": 150, "seller": {"name": "Acme", "city": "Madrid"}},
+		{"id": 4, "title": "Cable", "seller": {"name": "Initech", "city": "Sevilla"}}
+	]
+}`
+
+func TestNewTransformer(t *testing.T) {
+	for i, tc := range []struct {
+		steps    []TransformStep
+		expected string
+	}{
+		{
+			[]TransformStep{{Select: "meta.total"}},
+			`4`,
+		},
+		{
+			[]TransformStep{{Select: "results.title"}},
+			`["Mouse","Keyboard","Monitor","Cable"]`,
+		},
+		{
+			[]TransformStep{{Select: "results.-1.seller.city"}},
+			`"Sevilla"`,
+		},
+		{
+			[]TransformStep{{Select: "results.9"}},
+			`null`,
+		},
+		{
+			[]TransformStep{{Select: "results"}, {Sort: "price", Desc: true}, {Limit: 2}, {Pick: []string{"title", "seller.name"}}},
+			`[{"seller":{"name":"Acme"},"title":"Monitor"},{"seller":{"name":"Globex"},"title":"Keyboard"}]`,
+		},
+		{
+			[]TransformStep{{Select: "results"}, {Sort: "price"}, {Offset: 1, Limit: 1}, {Rename: map[string]string{"title": "name"}}, {Pick: []string{"name"}}},
+			`[{"name":"Mouse"}]`,
+		},
+		{
+			[]TransformStep{{Select: "results"}, {Sort: "seller.city"}, {Flatten: "seller"}, {Pick: []string{"id", "seller_city"}}},
+			`[{"id":2,"seller_city":"Bilbao"},{"id":1,"seller_city":"Madrid"},{"id":3,"seller_city":"Madrid"},{"id":4,"seller_city":"Sevilla"}]`,
+		},
+		{
+			[]TransformStep{{Select: "results.0"}, {Flatten: "seller"}, {Rename: map[string]string{"seller_name": "seller"}}, {Pick: []string{"seller", "title"}}},
+			`{"seller":"Acme","title":"Mouse"}`,
+		},
+		{
+			[]TransformStep{{Offset: 10}},
+			`{"meta":{"total":4},"results":[{"id":1,"price":20,"seller":{"city":"Madrid","name":"Acme"},"title":"Mouse"},{"id":2,"price":45.5,"seller":{"city":"Bilbao","name":"Globex"},"title":"Keyboard"},{"id":3,"price":150,"seller":{"city":"Madrid","name":"Acme"},"title":"Monitor"},{"id":4,"seller":{"city":"Sevilla","name":"Initech"},"title":"Cable"}]}`,
+		},
+	} {
+		transformer, err := NewTransformer(tc.steps)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewBufferString(transformTestPayload))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err != nil {
+			t.Error(err)
+			return
+		}
+		result, _ := json.Marshal(transformer(v))
+		if string(result) != tc.expected {
+			t.Errorf("#%d: unexpected result: %s", i, string(result))
+		}
+	}
+}
+
+func TestNewTransformer_ko(t *testing.T) {
+	for i, steps := range [][]TransformStep{
+		{{}},
+		{{Select: "a", Sort: "b"}},
+		{{Limit: -1}},
+		{{Pick: []string{"a", "."}}},
+		{{Flatten: "."}},
+	} {
+		if _, err := NewTransformer(steps); err == nil {
+			t.Errorf("#%d: error expected", i)
+		}
+	}
+}
+
+func TestTransformDecoder(t *testing.T) {
+	transformer, err := NewTransformer([]TransformStep{{Select: "results"}, {Limit: 2}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r := ResponseContext{}
+	if err := TransformDecoder(JSONValueDecoder, transformer)(bytes.NewBufferString(transformTestPayload), &r); err != nil {
+		t.Error(err)
+		return
+	}
+	if r.Data != nil || len(r.Array) != 2 || r.Array[1]["title"] != "Keyboard" {
+		t.Errorf("unexpected result: %v", r)
+	}
+
+	transformer, _ = NewTransformer([]TransformStep{{Sort: "name", Desc: true}})
+	r = ResponseContext{}
+	if err := TransformDecoder(CSVDecoder, transformer)(bytes.NewBufferString("name\na\nc\nb\n"), &r); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(r.Array) != 3 || r.Array[0]["name"] != "c" || r.Array[2]["name"] != "a" {
+		t.Errorf("unexpected result: %v", r.Array)
+	}
+
+	if err := TransformDecoder(JSONValueDecoder, transformer)(bytes.NewBufferString("{"), &r); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestNewHandlerConfig_transform(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, transformTestPayload)
+	}))
+	defer mockServer.Close()
+
+	cfg := NewHandlerConfig(Page{
+		Name:              "transform",
+		BackendURLPattern: mockServer.URL,
+		Transform:         []TransformStep{{Select: "meta"}},
+		Backends: []BackendConfig{
+			{Name: "sellers", URLPattern: mockServer.URL, Transform: []TransformStep{{Select: "results.seller.name"}}},
+			{Name: "broken", URLPattern: mockServer.URL, Transform: []TransformStep{{}}, Optional: true},
+		},
+	})
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+	result, err := cfg.ResponseGenerator(c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if total, ok := result.Data["total"].(json.Number); !ok || total.String() != "4" {
+		t.Errorf("unexpected data: %v", result.Data)
+	}
+	if sellers, ok := result.Backends["sellers"].([]interface{}); !ok || len(sellers) != 4 || sellers[3] != "Initech" {
+		t.Errorf("unexpected backends: %v", result.Backends)
+	}
+	if _, ok := result.Backends["broken"]; ok {
+		t.Errorf("unexpected backends: %v", result.Backends)
+	}
+}